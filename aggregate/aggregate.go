@@ -0,0 +1,313 @@
+package aggregate
+
+import (
+	"log"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Store identifies the InfluxDB database which aggregators read from and write to.
+type Store struct {
+	Influx             influxdb.Client
+	InfluxDB           string
+	InfluxRP           string
+	InfluxQueryTimeout time.Duration
+
+	// SourceDB, if set, is the database source data is read from; InfluxDB is still
+	// used for everything else, including reading back stored aggregates.
+	SourceDB string
+
+	// ArchiveRP, if set, is a retention policy holding older (e.g. downsampled)
+	// source data, which is read in addition to InfluxRP. See mergeArchivedSamples.
+	ArchiveRP string
+
+	// TimeColumn is the name of the time column in query results; defaults to "time".
+	TimeColumn string
+
+	// SourceQuery, if set, is an InfluxQL template used instead of the default source
+	// query. See expandSourceQuery for its placeholders.
+	SourceQuery string
+
+	// MultiSeries is how a source query returning more than one series (e.g. because
+	// the data has tags not filtered on) is handled: MultiSeriesError (the default)
+	// fails, and MultiSeriesMerge combines all series' samples into one stream.
+	MultiSeries string
+
+	// TolerantParse makes source values which are strings parse even if they're
+	// locale-formatted numbers, e.g. "1,234.5"; see tolerantToFloat.
+	TolerantParse bool
+
+	// ReadEpoch, if set, is the epoch precision ("ns", "u", "ms", or "s") in which
+	// queries ask InfluxDB to return timestamps, as integers rather than RFC3339
+	// strings. See ValidateReadEpoch.
+	ReadEpoch string
+
+	// ChunkSize, if positive, makes source queries request chunked responses of this
+	// many rows, which are parsed as they stream in rather than buffered whole.
+	ChunkSize int
+}
+
+const (
+	MultiSeriesError = "error"
+	MultiSeriesMerge = "merge"
+)
+
+func (s Store) timeColumn() string {
+	if s.TimeColumn == "" {
+		return "time"
+	}
+	return s.TimeColumn
+}
+
+// CommonArgs holds the settings shared by every aggregator.
+type CommonArgs struct {
+	MeasurementFrom string
+	MeasurementTo   string
+	QueryTags       map[string]string
+	WriteTags       map[string]string
+	FieldSuffix     string
+	SourceFilter    string            // partial WHERE clause applied to source queries, from ParsePredicate
+	SourceFields    map[string]string // maps field names to the source columns they are read from; may be nil
+	Filter          *SampleFilter     // outlier filter applied to source samples; may be nil
+
+	// MinSamples is the minimum number of source samples an interval needs for its
+	// aggregate to be written; intervals with fewer are skipped. 0 disables the check.
+	MinSamples int
+
+	// TimestampStrategy is where within its window an aggregate point is timestamped:
+	// TimestampTrailing, TimestampCentered, or TimestampLeading. Defaults to trailing.
+	TimestampStrategy string
+
+	// Layout is how interval aggregates are written: LayoutFields (interval-suffixed
+	// fields in MeasurementTo) or LayoutMeasurementPerInterval (unsuffixed fields in
+	// an interval-suffixed measurement). Defaults to LayoutFields.
+	Layout string
+
+	// WindowType is which window each interval is aggregated over: WindowSliding
+	// (the interval leading up to the latest sample, or for WindDirectionAgg, up to
+	// Now, so that after a gap in the data its windows are emptier than the others')
+	// or WindowTumbling (the last complete clock-aligned block, e.g. 14:00–15:00 for
+	// 1h at 15:20). Defaults to WindowSliding.
+	WindowType string
+
+	// WindowStart is whether a sample exactly one interval before a sliding window's
+	// end belongs to it: WindowStartInclusive (the default) or WindowStartExclusive.
+	// Tumbling windows always include their start and exclude their end.
+	WindowStart string
+
+	// WindowTolerance extends sliding windows backward, so that a sample timestamped
+	// up to this long before a window's start (e.g. by a skewed station clock) is
+	// still included. Tumbling windows partition time, so it doesn't apply to them.
+	WindowTolerance time.Duration
+
+	// Periods optionally aggregates the interval of each given length over a named
+	// clock-aligned Period, instead of the window WindowType gives it.
+	Periods map[time.Duration]Period
+
+	// NonFinite is how a NaN or infinite aggregate is handled: NonFiniteOmit (omit
+	// the field; the default), NonFiniteSentinel (write NonFiniteValue), or
+	// NonFiniteError (fail the aggregation).
+	NonFinite      string
+	NonFiniteValue float64
+
+	// FailOnEmpty makes a source query which returns no data an *EmptyResultError,
+	// rather than an aggregation which writes nothing.
+	FailOnEmpty bool
+
+	// Summary, if non-nil, records statistics about this aggregation.
+	Summary *RunSummary
+
+	// Now returns the current time; if nil, time.Now is used. Every aggregator's
+	// windows, including the time bounds of its queries, are relative to it.
+	// This allows using the InfluxDB server's clock (-use-server-time), and allows
+	// tests to freeze time and assert exact output timestamps.
+	Now func() time.Time
+}
+
+func (c CommonArgs) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+const (
+	WindowSliding  = "sliding"
+	WindowTumbling = "tumbling"
+)
+
+const (
+	WindowStartInclusive = "inclusive"
+	WindowStartExclusive = "exclusive"
+)
+
+// tumbling reports whether intervals are aggregated over tumbling windows, each of
+// which corresponds to a fixed block of time, so recomputing it yields the same point.
+func (c CommonArgs) tumbling() bool {
+	return c.WindowType == WindowTumbling
+}
+
+const (
+	LayoutFields                 = "fields"                   // e.g. weather_agg: wind_dir_mean_1h
+	LayoutMeasurementPerInterval = "measurement-per-interval" // e.g. weather_agg_1h: wind_dir_mean
+)
+
+// intervalFieldName returns the output field name for the aggregate named base
+// over the given interval, per the configured layout.
+func (c CommonArgs) intervalFieldName(base, interval string) string {
+	if c.Layout == LayoutMeasurementPerInterval {
+		return base + c.FieldSuffix
+	}
+	return base + "_" + interval + c.FieldSuffix
+}
+
+// intervalMeasurement returns the measurement to which aggregates over the given
+// interval are written, per the configured layout.
+func (c CommonArgs) intervalMeasurement(interval string) string {
+	if c.Layout == LayoutMeasurementPerInterval {
+		return c.MeasurementTo + "_" + interval
+	}
+	return c.MeasurementTo
+}
+
+const (
+	TimestampTrailing = "trailing" // the end of the window
+	TimestampCentered = "centered" // the midpoint of the window
+	TimestampLeading  = "leading"  // the start of the window
+)
+
+func (c CommonArgs) timestampOffset(window time.Duration) time.Duration {
+	switch c.TimestampStrategy {
+	case TimestampCentered:
+		return window / 2
+	case TimestampLeading:
+		return window
+	default:
+		return 0
+	}
+}
+
+// pointTime returns the timestamp for an aggregate over the window of the given
+// length ending at end.
+func (c CommonArgs) pointTime(end time.Time, window time.Duration) time.Time {
+	return end.Add(-c.timestampOffset(window))
+}
+
+// windowEnd is the inverse of pointTime: it returns the end of the window for an
+// aggregate point with the given timestamp.
+func (c CommonArgs) windowEnd(t time.Time, window time.Duration) time.Time {
+	return t.Add(c.timestampOffset(window))
+}
+
+// intervalDurations returns the durations of the named intervals.
+func intervalDurations(intervals []string, toDuration func(string) time.Duration) []time.Duration {
+	retv := make([]time.Duration, len(intervals))
+	for i, interval := range intervals {
+		retv[i] = toDuration(interval)
+	}
+	return retv
+}
+
+// alignedWindow returns the last complete window of length d ending at or before
+// now, aligned to UTC clock boundaries (so, e.g., 24h windows are UTC days).
+func alignedWindow(now time.Time, d time.Duration) (start, end time.Time) {
+	end = now.UTC().Truncate(d)
+	return end.Add(-d), end
+}
+
+// fixedWindow returns the window the interval of length d is aggregated over as of
+// now, if it's fixed rather than sliding: its Period's, if it has one, or with
+// tumbling windows, the last complete aligned window.
+func (c CommonArgs) fixedWindow(now time.Time, d time.Duration) (start, end time.Time, ok bool) {
+	if p, ok := c.Periods[d]; ok {
+		start, end = p.window(now)
+		return start, end, true
+	}
+	if c.tumbling() {
+		start, end = alignedWindow(now, d)
+		return start, end, true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// alignQuery returns sq restricted to the span covering the fixed windows (periods
+// or tumbling windows) of the given lengths. If they're all sliding, its Window up
+// to now is read, with the sliding window boundary settings applied; if only some
+// are, the span is extended to cover the sliding ones up to now.
+func (c CommonArgs) alignQuery(sq sampleQuery, durations ...time.Duration) sampleQuery {
+	now := c.now()
+	var sliding time.Duration
+	for _, d := range durations {
+		start, end, ok := c.fixedWindow(now, d)
+		if !ok {
+			sliding = max(sliding, d)
+			continue
+		}
+		if sq.Since.IsZero() || start.Before(sq.Since) {
+			sq.Since = start
+		}
+		if end.After(sq.Until) {
+			sq.Until = end
+		}
+	}
+	if sq.Since.IsZero() {
+		sq.Now = now
+		sq.ExclusiveStart = c.WindowStart == WindowStartExclusive
+		sq.Tolerance = c.WindowTolerance
+		return sq
+	}
+	if sliding > 0 {
+		if since := now.Add(-sliding - c.WindowTolerance); since.Before(sq.Since) {
+			sq.Since = since
+		}
+		sq.Until = time.Time{}
+	}
+	return sq
+}
+
+// inInterval reports whether a sample at t belongs to the interval of length d:
+// with a fixed window, whether it's within that window, and otherwise, whether
+// it's within d (plus WindowTolerance) before ref (e.g. the latest sample),
+// including the boundary unless WindowStart is exclusive.
+func (c CommonArgs) inInterval(ref, t time.Time, d time.Duration) bool {
+	start, end, ok := c.fixedWindow(c.now(), d)
+	if !ok {
+		age, limit := ref.Sub(t), d+c.WindowTolerance
+		if c.WindowStart == WindowStartExclusive {
+			return age < limit
+		}
+		return age <= limit
+	}
+	return !t.Before(start) && t.Before(end)
+}
+
+// intervalEnd returns the end of the interval of length d: with a fixed window, the
+// end of that window, and otherwise ref.
+func (c CommonArgs) intervalEnd(ref time.Time, d time.Duration) time.Time {
+	_, end, ok := c.fixedWindow(c.now(), d)
+	if !ok {
+		return ref
+	}
+	return end
+}
+
+// emptyResult logs that a source query returned no data, returning an
+// *EmptyResultError if FailOnEmpty is set.
+func (c CommonArgs) emptyResult(msg string) error {
+	if c.FailOnEmpty {
+		return &EmptyResultError{Msg: msg}
+	}
+	log.Print(msg)
+	return nil
+}
+
+// enoughSamples reports whether an interval with n source samples meets MinSamples,
+// logging when it doesn't.
+func (c CommonArgs) enoughSamples(what, interval string, n int) bool {
+	if n >= c.MinSamples {
+		return true
+	}
+	log.Printf("skipping %s %s: %d samples is fewer than the minimum of %d", what, interval, n, c.MinSamples)
+	return false
+}
@@ -0,0 +1,94 @@
+package aggregate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPointTime(t *testing.T) {
+	end := time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC)
+	tests := []struct {
+		strategy string
+		want     time.Time
+	}{
+		{"", end}, // the default
+		{TimestampTrailing, end},
+		{TimestampCentered, end.Add(-30 * time.Minute)},
+		{TimestampLeading, end.Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		args := CommonArgs{TimestampStrategy: tt.strategy}
+		got := args.pointTime(end, time.Hour)
+		if !got.Equal(tt.want) {
+			t.Errorf("pointTime with strategy %q = %s, want %s", tt.strategy, got, tt.want)
+		}
+		if back := args.windowEnd(got, time.Hour); !back.Equal(end) {
+			t.Errorf("windowEnd with strategy %q = %s, want %s", tt.strategy, back, end)
+		}
+	}
+}
+
+func TestInIntervalBoundaries(t *testing.T) {
+	ref := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	const d = time.Hour
+	const tol = 500 * time.Millisecond
+	tests := []struct {
+		name      string
+		start     string
+		tolerance time.Duration
+		age       time.Duration
+		want      bool
+	}{
+		{"inclusive, inside", WindowStartInclusive, 0, d - time.Nanosecond, true},
+		{"inclusive, at d", WindowStartInclusive, 0, d, true},
+		{"inclusive, past d", WindowStartInclusive, 0, d + time.Nanosecond, false},
+		{"exclusive, inside", WindowStartExclusive, 0, d - time.Nanosecond, true},
+		{"exclusive, at d", WindowStartExclusive, 0, d, false},
+		{"default is inclusive", "", 0, d, true},
+		{"inclusive, at d+tol", WindowStartInclusive, tol, d + tol, true},
+		{"inclusive, at d+tol+1ns", WindowStartInclusive, tol, d + tol + time.Nanosecond, false},
+		{"exclusive, at d+tol", WindowStartExclusive, tol, d + tol, false},
+		{"exclusive, just inside d+tol", WindowStartExclusive, tol, d + tol - time.Nanosecond, true},
+		{"exclusive, at d+tol+1ns", WindowStartExclusive, tol, d + tol + time.Nanosecond, false},
+		{"newest sample", WindowStartInclusive, tol, 0, true},
+	}
+	for _, tt := range tests {
+		args := CommonArgs{WindowStart: tt.start, WindowTolerance: tt.tolerance, Now: func() time.Time { return ref }}
+		if got := args.inInterval(ref, ref.Add(-tt.age), d); got != tt.want {
+			t.Errorf("%s: inInterval = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadSamplesWindowBoundaries(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		start     string
+		tolerance time.Duration
+		want      string
+	}{
+		{"inclusive", WindowStartInclusive, 0, "time >= '2024-06-01T12:00:00Z'-1h "},
+		{"exclusive", WindowStartExclusive, 0, "time > '2024-06-01T12:00:00Z'-1h "},
+		{"tolerance", WindowStartInclusive, 500 * time.Millisecond, "time >= '2024-06-01T12:00:00Z'-1h-500ms "},
+		{"tolerance rounded up", WindowStartExclusive, 1500 * time.Microsecond, "time > '2024-06-01T12:00:00Z'-1h-2ms "},
+	}
+	for _, tt := range tests {
+		client := &scriptedClient{}
+		args := CommonArgs{WindowStart: tt.start, WindowTolerance: tt.tolerance, Now: func() time.Time { return now }}
+		sq := args.alignQuery(sampleQuery{
+			Store:       Store{Influx: client},
+			Measurement: "wx",
+			Fields:      []string{"temp"},
+			Window:      "1h",
+		}, time.Hour)
+		if _, err := readSamples(context.Background(), sq, ""); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(client.commands) != 1 || !strings.Contains(client.commands[0], "WHERE "+tt.want) {
+			t.Errorf("%s: query %q, want one bounded by %q", tt.name, client.commands, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type AirQualityAggArgs struct {
+	CommonArgs
+	Store
+
+	PM25Field   string // in µg/m³
+	AQICategory bool
+}
+
+const aqInterval1h = "1h"
+
+func pm25MeanFieldName(args AirQualityAggArgs, interval string) string {
+	return args.intervalFieldName("pm25_mean", interval)
+}
+
+func pm25MaxFieldName(args AirQualityAggArgs, interval string) string {
+	return args.intervalFieldName("pm25_max", interval)
+}
+
+func aqiCategoryFieldName(args AirQualityAggArgs, interval string) string {
+	return args.intervalFieldName("aqi_category", interval)
+}
+
+// pm25AQIBreakpoints are the upper bounds (inclusive, in µg/m³) of each US EPA AQI
+// category for PM2.5, per the 2024 revision of the AQI (40 CFR Part 58, Appendix G).
+var pm25AQIBreakpoints = []struct {
+	upper    float64
+	category string
+}{
+	{9.0, "Good"},
+	{35.4, "Moderate"},
+	{55.4, "Unhealthy for Sensitive Groups"},
+	{125.4, "Unhealthy"},
+	{225.4, "Very Unhealthy"},
+	{math.Inf(1), "Hazardous"},
+}
+
+// PM25AQICategory returns the US EPA AQI category for the given PM2.5 concentration
+// (µg/m³). Per EPA guidance, the concentration is truncated to one decimal place
+// before the breakpoint table is consulted.
+func PM25AQICategory(pm25 float64) string {
+	c := math.Trunc(pm25*10) / 10
+	for _, bp := range pm25AQIBreakpoints {
+		if c <= bp.upper {
+			return bp.category
+		}
+	}
+	return pm25AQIBreakpoints[len(pm25AQIBreakpoints)-1].category
+}
+
+func AirQualityAgg(ctx context.Context, args AirQualityAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	samples, err := querySamples(ctx, args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       []string{args.PM25Field},
+		SourceFields: args.SourceFields,
+		Window:       aqInterval1h,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		Summary:      args.Summary,
+	}, time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, args.emptyResult("no air quality data to aggregate")
+	}
+	args.Summary.AddSamplesRead(len(samples))
+	warnPassthroughIntervals("air_quality", samples, aqInterval1h)
+	if !args.enoughSamples("air_quality", aqInterval1h, len(samples)) {
+		return nil, nil
+	}
+	args.Summary.RecordIntervals("air_quality", []string{aqInterval1h})
+
+	sum := 0.0
+	maxV := math.Inf(-1)
+	for _, s := range samples {
+		sum += s.values[0]
+		maxV = math.Max(maxV, s.values[0])
+	}
+	mean := sum / float64(len(samples))
+
+	fields := map[string]any{
+		pm25MeanFieldName(args, aqInterval1h): mean,
+		pm25MaxFieldName(args, aqInterval1h):  maxV,
+	}
+	if args.AQICategory {
+		fields[aqiCategoryFieldName(args, aqInterval1h)] = PM25AQICategory(mean)
+	}
+
+	// timestamp within the window per the configured strategy (by default, its end):
+	p, err := args.newPoint(
+		args.intervalMeasurement(aqInterval1h),
+		args.WriteTags,
+		fields,
+		args.pointTime(args.intervalEnd(samples[len(samples)-1].t, time.Hour), time.Hour),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+	}
+
+	if p == nil {
+		return nil, nil
+	}
+	return []*influxdb.Point{p}, nil
+}
@@ -0,0 +1,32 @@
+package aggregate
+
+import "testing"
+
+func TestPM25AQICategory(t *testing.T) {
+	tests := []struct {
+		pm25 float64
+		want string
+	}{
+		{0, "Good"},
+		{9.0, "Good"},
+		{9.09, "Good"}, // truncated to 9.0
+		{9.1, "Moderate"},
+		{12.0, "Moderate"},
+		{12.1, "Moderate"},
+		{35.4, "Moderate"},
+		{35.49, "Moderate"},
+		{35.5, "Unhealthy for Sensitive Groups"},
+		{55.4, "Unhealthy for Sensitive Groups"},
+		{55.5, "Unhealthy"},
+		{125.4, "Unhealthy"},
+		{125.5, "Very Unhealthy"},
+		{225.4, "Very Unhealthy"},
+		{225.5, "Hazardous"},
+		{1000, "Hazardous"},
+	}
+	for _, tt := range tests {
+		if got := PM25AQICategory(tt.pm25); got != tt.want {
+			t.Errorf("PM25AQICategory(%v) = %q, want %q", tt.pm25, got, tt.want)
+		}
+	}
+}
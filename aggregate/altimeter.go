@@ -0,0 +1,102 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/cdzombak/libwx"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type AltimeterAggArgs struct {
+	CommonArgs
+	Store
+
+	PressureField  string
+	AltitudeMeters float64
+}
+
+const altimeterInterval1h = "1h"
+
+func altimeterResultFieldName(args AltimeterAggArgs, interval string) string {
+	return args.intervalFieldName("altimeter_setting", interval)
+}
+
+// FieldUnits returns the units of this aggregation's output fields which have one.
+func (args AltimeterAggArgs) FieldUnits() FieldUnits {
+	return FieldUnits{"altimeter_setting": UnitInHg}
+}
+
+// AltimeterSetting returns the aviation altimeter setting for the given station
+// pressure and station elevation, using the formula from the NWS/FAA (see
+// https://www.weather.gov/media/epz/wxcalc/altimeterSetting.pdf), which assumes the
+// ICAO standard atmosphere: 1013.25 mb and 288 K at sea level, with a lapse rate
+// of 0.0065 K/m. The 0.3 mb offset corrects for the standard sensor height above
+// the station elevation.
+func AltimeterSetting(stationPressure libwx.PressureMb, altitudeMeters float64) libwx.PressureMb {
+	const n = 0.190284
+	p := stationPressure.Unwrap() - 0.3
+	return libwx.PressureMb(p * math.Pow(1+(math.Pow(1013.25, n)*0.0065/288)*(altitudeMeters/math.Pow(p, n)), 1/n))
+}
+
+func AltimeterAgg(ctx context.Context, args AltimeterAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	samples, err := querySamples(ctx, args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       []string{args.PressureField},
+		SourceFields: args.SourceFields,
+		Window:       altimeterInterval1h,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		Summary:      args.Summary,
+	}, time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	var latestTime time.Time
+	sum := 0.0
+	n := 0
+	for _, s := range samples {
+		sum += s.values[0]
+		n++
+		latestTime = s.t
+	}
+
+	if n == 0 {
+		return nil, args.emptyResult("no pressure data to aggregate")
+	}
+	args.Summary.AddSamplesRead(n)
+	warnPassthroughIntervals("pressure", samples, altimeterInterval1h)
+	if !args.enoughSamples("altimeter", altimeterInterval1h, n) {
+		return nil, nil
+	}
+	args.Summary.RecordIntervals("altimeter", []string{altimeterInterval1h})
+
+	altimeter := AltimeterSetting(libwx.PressureMb(sum/float64(n)), args.AltitudeMeters)
+
+	// timestamp within the window per the configured strategy (by default, its end):
+	p, err := args.newPoint(
+		args.intervalMeasurement(altimeterInterval1h),
+		args.WriteTags,
+		map[string]any{
+			altimeterResultFieldName(args, altimeterInterval1h): altimeter.InHg().Unwrap(),
+		},
+		args.pointTime(args.intervalEnd(latestTime, time.Hour), time.Hour),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+	}
+
+	if p == nil {
+		return nil, nil
+	}
+	return []*influxdb.Point{p}, nil
+}
@@ -0,0 +1,61 @@
+package aggregate
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// passthroughCadenceRatio is how many times longer than the source's reporting
+// cadence an interval must be for its aggregate to summarize more than a sample or
+// two; shorter intervals are nearly a passthrough of the raw data.
+const passthroughCadenceRatio = 3
+
+// cadenceWarned records the aggregation/interval pairs already warned about, so that
+// daemon mode warns once rather than every run.
+var cadenceWarned sync.Map
+
+// sampleCadence estimates the source's reporting cadence as the median gap between
+// consecutive samples. It returns 0 if there are too few samples to tell.
+func sampleCadence(samples []sample) time.Duration {
+	var gaps []time.Duration
+	for i := 1; i < len(samples); i++ {
+		if gap := samples[i].t.Sub(samples[i-1].t); gap > 0 {
+			gaps = append(gaps, gap)
+		}
+	}
+	if len(gaps) < 2 {
+		return 0
+	}
+	slices.Sort(gaps)
+	return gaps[len(gaps)/2]
+}
+
+// intervalCoverage returns the fraction of an interval of length d spanned by its
+// data, from the first sample to the last plus one cadence (the reporting period
+// the last sample represents), capped at 1. Gaps between samples aren't subtracted.
+func intervalCoverage(first, last time.Time, cadence, d time.Duration) float64 {
+	return min(1, float64(last.Sub(first)+cadence)/float64(d))
+}
+
+// warnPassthroughIntervals logs a warning for each of the given intervals which is
+// comparable to the samples' reporting cadence, since its aggregates will usually
+// summarize only one or two samples.
+func warnPassthroughIntervals(what string, samples []sample, intervals ...string) {
+	cadence := sampleCadence(samples)
+	if cadence == 0 {
+		return
+	}
+	for _, interval := range intervals {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d >= passthroughCadenceRatio*cadence {
+			continue
+		}
+		if _, warned := cadenceWarned.LoadOrStore(what+"/"+interval, true); warned {
+			continue
+		}
+		log.Printf("WARNING: the source reports %s data about every %s, so its %s aggregates will usually summarize only one or two samples",
+			what, cadence.Round(time.Second), interval)
+	}
+}
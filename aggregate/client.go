@@ -0,0 +1,39 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// ContextClient is an influxdb.Client whose queries are cancelled in flight once
+// their context is done, which influxdb.Client's can't be.
+type ContextClient interface {
+	influxdb.Client
+	QueryContext(ctx context.Context, q influxdb.Query) (*influxdb.Response, error)
+	QueryAsChunkContext(ctx context.Context, q influxdb.Query) (*influxdb.ChunkedResponse, error)
+}
+
+// QueryWithContext runs q with the given client, bounded by ctx. A client which
+// isn't a ContextClient can't be interrupted, so ctx is only checked before q is sent.
+func QueryWithContext(ctx context.Context, client influxdb.Client, q influxdb.Query) (*influxdb.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("query not sent: %w", err)
+	}
+	if cc, ok := client.(ContextClient); ok {
+		return cc.QueryContext(ctx, q)
+	}
+	return client.Query(q)
+}
+
+// QueryAsChunkWithContext is QueryWithContext, for a chunked query.
+func QueryAsChunkWithContext(ctx context.Context, client influxdb.Client, q influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("query not sent: %w", err)
+	}
+	if cc, ok := client.(ContextClient); ok {
+		return cc.QueryAsChunkContext(ctx, q)
+	}
+	return client.QueryAsChunk(q)
+}
@@ -0,0 +1,91 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// countingClient counts the queries it's asked to send.
+type countingClient struct {
+	influxdb.Client
+	queries int
+}
+
+func (c *countingClient) Query(influxdb.Query) (*influxdb.Response, error) {
+	c.queries++
+	return &influxdb.Response{}, nil
+}
+
+// scriptedClient answers each query whose command starts with a key of rows with
+// that key's series, and any other query with no data, or if err is set, fails
+// every query with it. It records every command.
+type scriptedClient struct {
+	influxdb.Client
+	rows     map[string]models.Row
+	err      error
+	commands []string
+}
+
+func (c *scriptedClient) Query(q influxdb.Query) (*influxdb.Response, error) {
+	c.commands = append(c.commands, q.Command)
+	if c.err != nil {
+		return nil, c.err
+	}
+	result := influxdb.Result{}
+	for prefix, row := range c.rows {
+		if strings.HasPrefix(q.Command, prefix) {
+			result.Series = []models.Row{row}
+		}
+	}
+	return &influxdb.Response{Results: []influxdb.Result{result}}, nil
+}
+
+// samplesRow returns a series of one field, with a sample of each value every
+// minute up to end.
+func samplesRow(field string, end time.Time, values ...float64) models.Row {
+	row := models.Row{Name: "wx", Columns: []string{"time", field}}
+	for i, v := range values {
+		t := end.Add(time.Duration(i-len(values)+1) * time.Minute)
+		row.Values = append(row.Values, []any{t.Format(time.RFC3339), json.Number(fmt.Sprint(v))})
+	}
+	return row
+}
+
+func TestQueryWithContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &countingClient{}
+	if _, err := QueryWithContext(ctx, c, influxdb.Query{Command: "SELECT 1"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if c.queries != 0 {
+		t.Errorf("sent %d queries after cancellation, want 0", c.queries)
+	}
+}
+
+func TestRunQueryStopsAtDeadline(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	c := &countingClient{}
+	_, err := runQuery(ctx, Store{Influx: c}, "SELECT 1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if c.queries != 0 {
+		t.Errorf("sent %d queries after the deadline, want 0", c.queries)
+	}
+	if _, err := QueryWithContext(context.Background(), c, influxdb.Query{Command: "SELECT 1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.queries != 1 {
+		t.Errorf("sent %d queries, want 1", c.queries)
+	}
+}
@@ -0,0 +1,111 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type CurrentArgs struct {
+	CommonArgs // SourceFilter and Summary are unused
+	Store
+
+	Fields []string // raw source fields to include
+}
+
+// currentLookback bounds how far back to look for each field's latest raw value.
+const currentLookback = "1h"
+
+// CurrentConditions builds a single point containing the most recent raw value of
+// each tracked field, plus the shortest-interval aggregate for each aggregate field
+// among aggPoints, named with its interval whatever the layout. The point is
+// timestamped at the latest source sample time.
+func CurrentConditions(ctx context.Context, args CurrentArgs, aggPoints []*influxdb.Point) (*influxdb.Point, error) {
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	samples, err := querySamples(ctx, sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       args.Fields,
+		SourceFields: args.SourceFields,
+		Window:       currentLookback,
+		TagsWhere:    tagsWhere,
+		Filter:       args.Filter,
+		MADExempt:    args.Fields, // a single latest value can't be judged against the window's spread
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, args.emptyResult("no recent data for current conditions")
+	}
+
+	fields := make(map[string]interface{})
+	for i, f := range args.Fields {
+		for j := len(samples) - 1; j >= 0; j-- {
+			if !math.IsNaN(samples[j].values[i]) {
+				fields[f] = samples[j].values[i]
+				break
+			}
+		}
+	}
+
+	shortest := make(map[string]time.Duration) // aggregate field name, sans interval -> shortest interval seen
+	shortestName := make(map[string]string)
+	for _, p := range aggPoints {
+		pFields, err := p.Fields()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read aggregate point fields: %w", err)
+		}
+		for name, v := range pFields {
+			base, interval := splitIntervalFieldName(strings.TrimSuffix(name, args.FieldSuffix))
+			if interval == 0 {
+				// in the measurement-per-interval layout, the interval is in the measurement
+				// name. it's restored to the field name here, so that the aggregate can't
+				// overwrite a raw field of the same name (e.g. lightning_count):
+				if _, interval = splitIntervalFieldName(p.Name()); interval != 0 {
+					name = base + "_" + intervalName(interval) + args.FieldSuffix
+				}
+			}
+			if prev, ok := shortest[base]; ok && prev <= interval {
+				continue
+			}
+			if prevName, ok := shortestName[base]; ok {
+				delete(fields, prevName)
+			}
+			shortest[base] = interval
+			shortestName[base] = name
+			fields[name] = v
+		}
+	}
+
+	p, err := args.newPoint(
+		args.MeasurementTo,
+		args.WriteTags,
+		fields,
+		samples[len(samples)-1].t,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+	}
+	return p, nil
+}
+
+// splitIntervalFieldName splits an aggregate field name like "wind_dir_mean_5m" into
+// its base ("wind_dir_mean") and interval (5m). Names without a trailing interval
+// (e.g. "rain_rate") are returned whole, with a zero interval.
+func splitIntervalFieldName(name string) (string, time.Duration) {
+	idx := strings.LastIndex(name, "_")
+	if idx < 0 {
+		return name, 0
+	}
+	d, err := time.ParseDuration(name[idx+1:])
+	if err != nil {
+		return name, 0
+	}
+	return name[:idx], d
+}
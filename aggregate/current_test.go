@@ -0,0 +1,47 @@
+package aggregate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestCurrentConditionsKeepsRawFieldsDistinct(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	client := &scriptedClient{rows: map[string]models.Row{
+		"SELECT time, lightning_count FROM": samplesRow("lightning_count", now, 40, 41),
+	}}
+	var aggPoints []*influxdb.Point
+	for interval, count := range map[string]int64{"1h": 3, "24h": 12} {
+		p, err := influxdb.NewPoint("wx_agg_"+interval, nil, map[string]any{"lightning_count": count}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aggPoints = append(aggPoints, p)
+	}
+
+	p, err := CurrentConditions(context.Background(), CurrentArgs{
+		CommonArgs: CommonArgs{MeasurementFrom: "wx", MeasurementTo: "wx_current", Now: func() time.Time { return now }},
+		Store:      Store{Influx: client},
+		Fields:     []string{"lightning_count"},
+	}, aggPoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fields["lightning_count"]; got != 41.0 {
+		t.Errorf("lightning_count = %v, want the raw value 41", got)
+	}
+	if got := fields["lightning_count_1h"]; got != int64(3) {
+		t.Errorf("lightning_count_1h = %v, want 3", got)
+	}
+	if _, ok := fields["lightning_count_24h"]; ok {
+		t.Error("included lightning_count_24h; only the shortest interval belongs")
+	}
+}
@@ -0,0 +1,198 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/cdzombak/libwx"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type DewpointAggArgs struct {
+	CommonArgs
+	Store
+
+	TempField     string
+	TempUnit      TempUnit // unit of TempField and DewpointField, and of the output; defaults to Celsius
+	HumidityField string   // relative humidity (%); used to derive dewpoint if DewpointField is not given
+	DewpointField string   // optional; a station-reported dewpoint
+
+	// Check selects what happens to physically impossible samples (see
+	// impossibleHumidity): DewpointCheckOff (the default) ignores them,
+	// DewpointCheckDrop drops them, and DewpointCheckFlag keeps them but writes a
+	// flag field reporting whether any were seen.
+	Check string
+}
+
+const (
+	DewpointCheckOff  = "off"
+	DewpointCheckDrop = "drop"
+	DewpointCheckFlag = "flag"
+)
+
+const (
+	// humidityTolerance (%) allows for sensor accuracy near saturation;
+	// dewpointTolerance (degrees, in either unit) for rounding of reported values.
+	humidityTolerance = 3.0
+	dewpointTolerance = 0.5
+)
+
+// TempUnit is the unit in which source temperature fields are recorded.
+type TempUnit string
+
+const (
+	TempUnitC TempUnit = "C"
+	TempUnitF TempUnit = "F"
+)
+
+// ParseTempUnit parses "C" or "F".
+func ParseTempUnit(s string) (TempUnit, error) {
+	switch u := TempUnit(s); u {
+	case TempUnitC, TempUnitF:
+		return u, nil
+	default:
+		return "", fmt.Errorf("temperature unit must be C or F (got '%s')", s)
+	}
+}
+
+// DewPoint returns the dewpoint, in this unit, for a temperature in this unit.
+func (u TempUnit) DewPoint(t float64, rh libwx.RelHumidity) float64 {
+	if u == TempUnitF {
+		return libwx.DewPointF(libwx.TempF(t), rh).Unwrap()
+	}
+	return libwx.DewPointC(libwx.TempC(t), rh).Unwrap()
+}
+
+const dewpointInterval1h = "1h"
+
+func dewpointSpreadFieldName(args DewpointAggArgs, interval string) string {
+	return args.intervalFieldName("dewpoint_spread", interval)
+}
+
+func dewpointSuspectFieldName(args DewpointAggArgs, interval string) string {
+	return args.intervalFieldName("dewpoint_suspect", interval)
+}
+
+// FieldUnits returns the units of this aggregation's output fields which have one.
+func (args DewpointAggArgs) FieldUnits() FieldUnits {
+	if args.TempUnit == TempUnitF {
+		return FieldUnits{"dewpoint_spread": UnitDeltaF}
+	}
+	return FieldUnits{"dewpoint_spread": UnitDeltaC}
+}
+
+// impossibleHumidity reports whether a sample's temperature and humidity (or
+// reported dewpoint) can't both be right: relative humidity outside 0–100%, or a
+// dewpoint above the air temperature, each beyond a small tolerance. Either usually
+// means a miscalibrated or failing humidity sensor.
+func impossibleHumidity(args DewpointAggArgs, temp, other float64) bool {
+	if args.DewpointField != "" {
+		return other > temp+dewpointTolerance
+	}
+	return other < 0 || other > 100+humidityTolerance
+}
+
+// DewpointAgg computes the mean dewpoint spread (temperature minus dewpoint) over
+// the past hour, a fog-risk indicator. The spread is computed per sample and then
+// averaged, which is more accurate than differencing mean temperature and mean
+// dewpoint since dewpoint is nonlinear in humidity.
+func DewpointAgg(ctx context.Context, args DewpointAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	tempUnit := args.TempUnit
+	if tempUnit == "" {
+		tempUnit = TempUnitC
+	}
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	fields := []string{args.TempField, args.HumidityField}
+	if args.DewpointField != "" {
+		fields = []string{args.TempField, args.DewpointField}
+	}
+	samples, err := querySamples(ctx, args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       fields,
+		SourceFields: args.SourceFields,
+		Window:       dewpointInterval1h,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		Summary:      args.Summary,
+	}, time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	var latestTime time.Time
+	sum := 0.0
+	n := 0
+	impossible := 0
+	for _, s := range samples {
+		t, other := s.values[0], s.values[1]
+		if math.IsNaN(t) || math.IsNaN(other) {
+			continue
+		}
+		if args.Check == DewpointCheckDrop || args.Check == DewpointCheckFlag {
+			if impossibleHumidity(args, t, other) {
+				impossible++
+				if args.Check == DewpointCheckDrop {
+					continue
+				}
+			}
+		}
+		dewpoint := other
+		if args.DewpointField == "" {
+			dewpoint = tempUnit.DewPoint(t, libwx.ClampedRelHumidity(int(math.Round(other))))
+		}
+		sum += t - dewpoint
+		n++
+		latestTime = s.t
+	}
+
+	if impossible > 0 {
+		if args.Check == DewpointCheckDrop {
+			log.Printf("dropped %d samples with impossible temperature/%s combinations", impossible, fields[1])
+			args.Summary.AddSamplesDropped(impossible)
+		} else {
+			log.Printf("WARNING: %d samples have impossible temperature/%s combinations", impossible, fields[1])
+		}
+	}
+
+	if n == 0 {
+		return nil, args.emptyResult("no temperature/humidity data to aggregate")
+	}
+	args.Summary.AddSamplesRead(n)
+	warnPassthroughIntervals("dewpoint", samples, dewpointInterval1h)
+	if !args.enoughSamples("dewpoint", dewpointInterval1h, n) {
+		return nil, nil
+	}
+	args.Summary.RecordIntervals("dewpoint", []string{dewpointInterval1h})
+
+	out := map[string]any{
+		dewpointSpreadFieldName(args, dewpointInterval1h): sum / float64(n),
+	}
+	if args.Check == DewpointCheckFlag {
+		out[dewpointSuspectFieldName(args, dewpointInterval1h)] = impossible > 0
+	}
+
+	// timestamp within the window per the configured strategy (by default, its end):
+	p, err := args.newPoint(
+		args.intervalMeasurement(dewpointInterval1h),
+		args.WriteTags,
+		out,
+		args.pointTime(args.intervalEnd(latestTime, time.Hour), time.Hour),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+	}
+
+	if p == nil {
+		return nil, nil
+	}
+	return []*influxdb.Point{p}, nil
+}
@@ -0,0 +1,32 @@
+// Package aggregate computes weather station aggregates from raw samples stored in
+// InfluxDB 1.x. It is the library behind the wx-sta-agg-influx command, which is a
+// thin wrapper handling flags, scheduling, retries, and writes.
+//
+// Each aggregator is a function taking an XxxAggArgs struct and returning the
+// points it computed; the caller decides whether and where to write them:
+//
+//	points, err := aggregate.WindDirectionAgg(ctx, aggregate.WindDirectionAggArgs{
+//		Store: aggregate.Store{
+//			Influx:             client, // an influxdb1-client/v2 Client
+//			InfluxDB:           "weather",
+//			InfluxQueryTimeout: 10 * time.Second,
+//		},
+//		CommonArgs: aggregate.CommonArgs{
+//			MeasurementFrom: "weather",
+//			MeasurementTo:   "weather_agg",
+//			QueryTags:       map[string]string{"station": "home"},
+//			WriteTags:       map[string]string{"station": "home"},
+//		},
+//		WindDirectionField: "wind_dir",
+//		WindSpeedField:     "wind_speed",
+//	})
+//
+// Every XxxAggArgs embeds Store, identifying the database to read, and CommonArgs,
+// holding the settings shared by all aggregators. CurrentConditions builds a single
+// point summarizing the latest aggregates, and DropUnchangedPoints removes points
+// identical to those already stored.
+//
+// Errors from InfluxDB queries are returned as *QueryError; source data with an
+// unexpected shape or type yields *SchemaError or *ParseError. Callers can use
+// errors.As to decide whether a failure is worth retrying.
+package aggregate
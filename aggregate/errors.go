@@ -0,0 +1,107 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// QueryError is returned when InfluxDB fails to execute a query, whether because
+// of a network problem or an error reported by the server. It may succeed on retry.
+type QueryError struct {
+	Query string
+	Err   error
+}
+
+func (e *QueryError) Error() string { return fmt.Sprintf("InfluxDB query failed: %s", e.Err) }
+func (e *QueryError) Unwrap() error { return e.Err }
+
+// SchemaError is returned when a query result doesn't have the expected shape,
+// e.g. more than one series or a missing column. It will not succeed on retry.
+type SchemaError struct {
+	Msg string
+
+	// Field is the source field whose column is missing from the result, if that's
+	// the problem, e.g. because the station lacks that sensor.
+	Field string
+}
+
+func (e *SchemaError) Error() string { return "unexpected query result: " + e.Msg }
+
+// EmptyResultError is returned when a source query returns no data and
+// CommonArgs.FailOnEmpty is set.
+type EmptyResultError struct {
+	Msg string
+}
+
+func (e *EmptyResultError) Error() string { return e.Msg }
+
+// ParseError is returned when a value in a query result can't be parsed.
+// It will not succeed on retry.
+type ParseError struct {
+	What string
+	Err  error
+}
+
+func (e *ParseError) Error() string { return fmt.Sprintf("failed to parse %s: %s", e.What, e.Err) }
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// runQuery runs the given InfluxQL query against the store, returning a *QueryError
+// if it fails to execute or ctx is done before it is sent.
+func runQuery(ctx context.Context, store Store, q string) (*influxdb.Response, error) {
+	log.Printf("[DEBUG] query: %s", q)
+	r, err := QueryWithContext(ctx, store.Influx, influxdb.Query{
+		Command:         q,
+		Database:        store.InfluxDB,
+		RetentionPolicy: store.InfluxRP,
+		Precision:       store.ReadEpoch,
+	})
+	if err != nil {
+		return nil, &QueryError{Query: q, Err: err}
+	}
+	if r.Err != "" {
+		return nil, &QueryError{Query: q, Err: errors.New(r.Err)}
+	}
+	return r, nil
+}
+
+// runChunkedQuery runs the given InfluxQL query against the store like runQuery, but
+// requests a chunked response of store.ChunkSize rows per chunk, calling each with
+// every chunk as it's decoded. It stops at the first error each returns.
+func runChunkedQuery(ctx context.Context, store Store, q string, each func(*influxdb.Response) error) error {
+	log.Printf("[DEBUG] chunked query: %s", q)
+	cr, err := QueryAsChunkWithContext(ctx, store.Influx, influxdb.Query{
+		Command:         q,
+		Database:        store.InfluxDB,
+		RetentionPolicy: store.InfluxRP,
+		Precision:       store.ReadEpoch,
+		Chunked:         true,
+		ChunkSize:       store.ChunkSize,
+	})
+	if err != nil {
+		return &QueryError{Query: q, Err: err}
+	}
+	defer cr.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("chunked query interrupted: %w", err)
+		}
+		r, err := cr.NextResponse()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return &QueryError{Query: q, Err: err}
+		}
+		if err := r.Error(); err != nil {
+			return &QueryError{Query: q, Err: err}
+		}
+		if err := each(r); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// TestErrorTypes checks which structured error each class of failure produces.
+func TestErrorTypes(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	malformed := samplesRow("temp", now, 20, 21)
+	malformed.Values[1][1] = "warm"
+	missing := samplesRow("humidity", now, 50, 51)
+
+	tests := []struct {
+		name   string
+		client *scriptedClient
+		want   string
+		check  func(error) bool
+	}{
+		{"query error", &scriptedClient{err: errors.New("dial tcp: connection refused")}, "*QueryError", func(err error) bool {
+			var qe *QueryError
+			return errors.As(err, &qe) && qe.Query != ""
+		}},
+		{"malformed value", &scriptedClient{rows: map[string]models.Row{"SELECT": malformed}}, "*ParseError of temp", func(err error) bool {
+			var pe *ParseError
+			return errors.As(err, &pe) && pe.What == "temp"
+		}},
+		{"missing field", &scriptedClient{rows: map[string]models.Row{"SELECT": missing}}, "*SchemaError for temp", func(err error) bool {
+			var se *SchemaError
+			return errors.As(err, &se) && se.Field == "temp"
+		}},
+		{"empty result", &scriptedClient{}, "*EmptyResultError", func(err error) bool {
+			var ee *EmptyResultError
+			return errors.As(err, &ee)
+		}},
+	}
+	for _, tt := range tests {
+		_, err := NumericAgg(context.Background(), NumericAggArgs{
+			CommonArgs: CommonArgs{
+				MeasurementFrom: "wx",
+				MeasurementTo:   "wx_agg",
+				FailOnEmpty:     true,
+				Now:             func() time.Time { return now },
+			},
+			Store:  Store{Influx: tt.client},
+			Fields: []NumericField{{Name: "temp", Stats: []string{NumericStatMean}}},
+		})
+		if err == nil || !tt.check(err) {
+			t.Errorf("%s: got %T %v, want a %s", tt.name, err, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// HealthAggArgs configures aggregation of station telemetry: battery level and
+// radio signal strength. At least one of BatteryField and SignalField must be set.
+type HealthAggArgs struct {
+	CommonArgs
+	Store
+
+	BatteryField string
+	// BatteryType is how BatteryField is reported: BatteryVoltage (a level, low
+	// below BatteryLowThreshold) or BatteryStatus (a status such as OK/LOW, low
+	// if it matches one of BatteryLowValues). Defaults to BatteryVoltage.
+	BatteryType         string
+	BatteryLowThreshold float64
+	BatteryLowValues    []string // compared case-insensitively; defaults to DefaultBatteryLowValues
+
+	SignalField string
+}
+
+const (
+	BatteryVoltage = "voltage"
+	BatteryStatus  = "status"
+)
+
+// DefaultBatteryLowValues are the status values which indicate a low battery,
+// covering OK/LOW strings, 0/1 flags, and booleans.
+var DefaultBatteryLowValues = []string{"low", "1", "true"}
+
+const (
+	healthInterval24h = "24h"
+	healthInterval1h  = "1h"
+)
+
+func allHealthIntervals() []string {
+	return []string{healthInterval24h, healthInterval1h}
+}
+
+func healthIntervalToDuration(interval string) time.Duration {
+	switch interval {
+	case healthInterval24h:
+		return 24 * time.Hour
+	case healthInterval1h:
+		return time.Hour
+	default:
+		panic(fmt.Sprintf("unknown health interval: %s", interval))
+	}
+}
+
+func healthResultFieldName(args HealthAggArgs, name, interval string) string {
+	return args.intervalFieldName(name, interval)
+}
+
+// batteryStatusParser returns a value parser mapping a battery status to 1 if it is
+// one of lowValues, or 0 otherwise.
+func batteryStatusParser(lowValues []string) func(v any) (float64, error) {
+	return func(v any) (float64, error) {
+		s := strings.TrimSpace(fmt.Sprint(v))
+		if slices.ContainsFunc(lowValues, func(low string) bool { return strings.EqualFold(s, low) }) {
+			return 1, nil
+		}
+		return 0, nil
+	}
+}
+
+func HealthAgg(ctx context.Context, args HealthAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+	batteryStatus := args.BatteryType == BatteryStatus
+	lowValues := args.BatteryLowValues
+	if len(lowValues) == 0 {
+		lowValues = DefaultBatteryLowValues
+	}
+
+	var fields []string
+	var madExempt []string
+	parsers := make(map[string]func(v any) (float64, error))
+	batteryIdx, signalIdx := -1, -1
+	if args.BatteryField != "" {
+		batteryIdx = len(fields)
+		fields = append(fields, args.BatteryField)
+		if batteryStatus {
+			parsers[args.BatteryField] = batteryStatusParser(lowValues)
+			madExempt = append(madExempt, args.BatteryField) // categorical
+		}
+	}
+	if args.SignalField != "" {
+		signalIdx = len(fields)
+		fields = append(fields, args.SignalField)
+	}
+
+	// query for the longest interval; shorter intervals will filter from this data.
+	samples, err := querySamples(ctx, args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       fields,
+		SourceFields: args.SourceFields,
+		Window:       healthInterval24h,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Parsers:      parsers,
+		Filter:       args.Filter,
+		MADExempt:    madExempt,
+		Summary:      args.Summary,
+	}, intervalDurations(allHealthIntervals(), healthIntervalToDuration)...))
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, args.emptyResult("no station health data to aggregate")
+	}
+	args.Summary.AddSamplesRead(len(samples))
+	warnPassthroughIntervals("station health", samples, allHealthIntervals()...)
+
+	latestTime := samples[len(samples)-1].t
+	var retv []*influxdb.Point
+
+	for _, interval := range allHealthIntervals() {
+		dur := healthIntervalToDuration(interval)
+		intervalValues := func(idx int) []float64 {
+			var retv []float64
+			for _, s := range samples {
+				if args.inInterval(latestTime, s.t, dur) && !math.IsNaN(s.values[idx]) {
+					retv = append(retv, s.values[idx])
+				}
+			}
+			return retv
+		}
+		out := make(map[string]interface{})
+
+		if batteryIdx >= 0 {
+			values := intervalValues(batteryIdx)
+			if len(values) > 0 && args.enoughSamples("battery", interval, len(values)) {
+				if batteryStatus {
+					out[healthResultFieldName(args, "battery_low", interval)] = slices.Contains(values, 1)
+				} else {
+					minV := numericStat(NumericStatMin, values)
+					out[healthResultFieldName(args, "battery_mean", interval)] = numericStat(NumericStatMean, values)
+					out[healthResultFieldName(args, "battery_min", interval)] = minV
+					out[healthResultFieldName(args, "battery_low", interval)] = minV < args.BatteryLowThreshold
+				}
+			}
+		}
+		if signalIdx >= 0 {
+			values := intervalValues(signalIdx)
+			if len(values) > 0 && args.enoughSamples("signal", interval, len(values)) {
+				out[healthResultFieldName(args, "signal_mean", interval)] = numericStat(NumericStatMean, values)
+			}
+		}
+
+		if len(out) == 0 {
+			continue
+		}
+
+		point, err := args.newPoint(
+			args.intervalMeasurement(interval),
+			args.WriteTags,
+			out,
+			args.pointTime(args.intervalEnd(latestTime, dur), dur),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+		if point != nil {
+			retv = append(retv, point)
+		}
+		args.Summary.RecordIntervals("health", []string{interval})
+	}
+
+	return retv, nil
+}
@@ -0,0 +1,147 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type LightningAggArgs struct {
+	CommonArgs
+	Store
+
+	CountField    string
+	DistanceField string // optional; in km
+
+	// NearestTimeAsTag writes the time of the nearest strike as a tag, rather than
+	// a field, so that it can be grouped and filtered by. Every distinct time is a
+	// new series.
+	NearestTimeAsTag bool
+}
+
+const lightningInterval1h = "1h"
+
+func lightningCountFieldName(args LightningAggArgs, interval string) string {
+	return args.intervalFieldName("lightning_count", interval)
+}
+
+func lightningNearestFieldName(args LightningAggArgs, interval string) string {
+	return args.intervalFieldName("lightning_nearest_km", interval)
+}
+
+// FieldUnits returns the units of this aggregation's output fields which have one.
+func (args LightningAggArgs) FieldUnits() FieldUnits {
+	return FieldUnits{"lightning_nearest_km": UnitKm}
+}
+
+func lightningNearestTimeFieldName(args LightningAggArgs, interval string) string {
+	return args.intervalFieldName("lightning_nearest_time", interval)
+}
+
+func LightningAgg(ctx context.Context, args LightningAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	fields := []string{args.CountField}
+	if args.DistanceField != "" {
+		fields = append(fields, args.DistanceField)
+	}
+	sq := args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       fields,
+		SourceFields: args.SourceFields,
+		Window:       lightningInterval1h,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		MADExempt:    fields, // strike counts are mostly zero, and distance is only meaningful with a strike
+		Summary:      args.Summary,
+	}, time.Hour)
+	samples, err := querySamples(ctx, sq)
+	if err != nil {
+		return nil, err
+	}
+
+	var latestTime time.Time
+	var count int64
+	nearest := math.Inf(1)
+	var nearestTime time.Time
+	n := 0
+	for _, s := range samples {
+		strikes := s.values[0]
+		if math.IsNaN(strikes) {
+			continue
+		}
+		n++
+		latestTime = s.t
+		if strikes <= 0 {
+			continue
+		}
+		count += int64(math.Round(strikes))
+
+		// detectors typically keep reporting the last strike's distance; only
+		// consider the distance from samples which recorded a strike:
+		if args.DistanceField == "" || math.IsNaN(s.values[1]) {
+			continue
+		}
+		if s.values[1] < nearest {
+			nearest = s.values[1]
+			nearestTime = s.t
+		}
+	}
+
+	if n == 0 {
+		// detectors may write nothing while there's no lightning, which is still a
+		// count of 0 if the station was reporting:
+		reporting, err := sourceHasRows(ctx, sq)
+		if err != nil {
+			return nil, err
+		}
+		if !reporting {
+			return nil, args.emptyResult("no lightning data to aggregate")
+		}
+		latestTime = args.now()
+	}
+	args.Summary.AddSamplesRead(n)
+	warnPassthroughIntervals("lightning", samples, lightningInterval1h)
+	// MinSamples doesn't apply: a strike count is a total, not a statistic which
+	// needs many samples to mean something, and it's written even with none.
+	args.Summary.RecordIntervals("lightning", []string{lightningInterval1h})
+
+	resultFields := map[string]any{
+		lightningCountFieldName(args, lightningInterval1h): count,
+	}
+	tags := args.WriteTags
+	if !math.IsInf(nearest, 1) {
+		resultFields[lightningNearestFieldName(args, lightningInterval1h)] = nearest
+		if args.NearestTimeAsTag {
+			tags = make(map[string]string, len(args.WriteTags)+1)
+			maps.Copy(tags, args.WriteTags)
+			tags[lightningNearestTimeFieldName(args, lightningInterval1h)] = nearestTime.UTC().Format(time.RFC3339)
+		} else {
+			resultFields[lightningNearestTimeFieldName(args, lightningInterval1h)] = nearestTime.UTC().Format(time.RFC3339)
+		}
+	}
+
+	// timestamp within the window per the configured strategy (by default, its end):
+	p, err := args.newPoint(
+		args.intervalMeasurement(lightningInterval1h),
+		tags,
+		resultFields,
+		args.pointTime(args.intervalEnd(latestTime, time.Hour), time.Hour),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+	}
+
+	if p == nil {
+		return nil, nil
+	}
+	return []*influxdb.Point{p}, nil
+}
@@ -0,0 +1,49 @@
+package aggregate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// TestLightningAggIgnoresMinSamples checks that the strike count is written
+// whatever -min-samples is, with no count samples as with one.
+func TestLightningAggIgnoresMinSamples(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	reporting := samplesRow("temp", now, 20)
+	tests := []struct {
+		name string
+		rows map[string]models.Row
+		want int64
+	}{
+		{"no strikes", map[string]models.Row{"SELECT * FROM": reporting}, 0},
+		{"one strike", map[string]models.Row{"SELECT time, lightning_count FROM": samplesRow("lightning_count", now, 1)}, 1},
+	}
+	for _, tt := range tests {
+		points, err := LightningAgg(context.Background(), LightningAggArgs{
+			CommonArgs: CommonArgs{
+				MeasurementFrom: "wx",
+				MeasurementTo:   "wx_agg",
+				MinSamples:      2,
+				Now:             func() time.Time { return now },
+			},
+			Store:      Store{Influx: &scriptedClient{rows: tt.rows}},
+			CountField: "lightning_count",
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(points) != 1 {
+			t.Fatalf("%s: got %d points, want 1", tt.name, len(points))
+		}
+		fields, err := points[0].Fields()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fields["lightning_count_1h"]; got != tt.want {
+			t.Errorf("%s: lightning_count_1h = %v, want %d", tt.name, got, tt.want)
+		}
+	}
+}
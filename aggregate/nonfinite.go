@@ -0,0 +1,62 @@
+package aggregate
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// How a non-finite (NaN or ±Inf) aggregate is handled. InfluxDB can't store them,
+// so writing one would fail the point.
+const (
+	NonFiniteOmit     = "omit"     // omit the field
+	NonFiniteSentinel = "sentinel" // write NonFiniteValue instead
+	NonFiniteError    = "error"    // fail the aggregation
+)
+
+// DefaultNonFiniteSentinel is the default value written in place of a non-finite
+// aggregate with NonFiniteSentinel.
+const DefaultNonFiniteSentinel = -9999.0
+
+// newPoint creates an aggregate point, first handling any non-finite float field
+// per NonFinite. It returns a nil point if every field was omitted.
+func (c CommonArgs) newPoint(name string, tags map[string]string, fields map[string]any, t time.Time) (*influxdb.Point, error) {
+	fields, err := c.guardNonFinite(fields)
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	return influxdb.NewPoint(name, tags, fields, t)
+}
+
+// guardNonFinite returns fields with each non-finite float value omitted or
+// replaced per NonFinite, logging each one. Fields are cloned before modification.
+func (c CommonArgs) guardNonFinite(fields map[string]any) (map[string]any, error) {
+	var retv map[string]any
+	for name, v := range fields {
+		f, ok := v.(float64)
+		if !ok || !(math.IsNaN(f) || math.IsInf(f, 0)) {
+			continue
+		}
+		if retv == nil {
+			retv = maps.Clone(fields)
+		}
+		switch c.NonFinite {
+		case NonFiniteError:
+			return nil, fmt.Errorf("aggregate %s is %v", name, f)
+		case NonFiniteSentinel:
+			log.Printf("WARNING: aggregate %s is %v; writing %v instead", name, f, c.NonFiniteValue)
+			retv[name] = c.NonFiniteValue
+		default:
+			log.Printf("WARNING: aggregate %s is %v; omitting it", name, f)
+			delete(retv, name)
+		}
+	}
+	if retv == nil {
+		return fields, nil
+	}
+	return retv, nil
+}
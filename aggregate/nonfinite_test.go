@@ -0,0 +1,88 @@
+package aggregate
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuardNonFinite(t *testing.T) {
+	fields := map[string]any{
+		"finite":  1.5,
+		"nan":     math.NaN(),
+		"inf":     math.Inf(1),
+		"neg_inf": math.Inf(-1),
+		"count":   int64(3),
+		"label":   "NW",
+	}
+
+	t.Run("omit", func(t *testing.T) {
+		got, err := CommonArgs{NonFinite: NonFiniteOmit}.guardNonFinite(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 || got["finite"] != 1.5 || got["count"] != int64(3) || got["label"] != "NW" {
+			t.Errorf("got %v, want only the finite, count, and label fields", got)
+		}
+		if len(fields) != 6 {
+			t.Error("modified the given fields")
+		}
+	})
+	t.Run("default omits", func(t *testing.T) {
+		got, err := CommonArgs{}.guardNonFinite(fields)
+		if err != nil || len(got) != 3 {
+			t.Errorf("got %v, %v; want 3 fields", got, err)
+		}
+	})
+	t.Run("sentinel", func(t *testing.T) {
+		got, err := CommonArgs{NonFinite: NonFiniteSentinel, NonFiniteValue: -9999}.guardNonFinite(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"nan", "inf", "neg_inf"} {
+			if got[name] != -9999.0 {
+				t.Errorf("%s = %v, want -9999", name, got[name])
+			}
+		}
+		if got["finite"] != 1.5 || len(got) != 6 {
+			t.Errorf("got %v, want the finite fields unchanged", got)
+		}
+		if !math.IsNaN(fields["nan"].(float64)) {
+			t.Error("modified the given fields")
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		_, err := CommonArgs{NonFinite: NonFiniteError}.guardNonFinite(map[string]any{"finite": 1.5, "nan": math.NaN()})
+		if err == nil || !strings.Contains(err.Error(), "nan") {
+			t.Errorf("err = %v, want one naming the nan field", err)
+		}
+	})
+	t.Run("all finite", func(t *testing.T) {
+		for _, mode := range []string{NonFiniteOmit, NonFiniteSentinel, NonFiniteError} {
+			got, err := CommonArgs{NonFinite: mode}.guardNonFinite(map[string]any{"finite": 1.5})
+			if err != nil || len(got) != 1 || got["finite"] != 1.5 {
+				t.Errorf("%s: got %v, %v", mode, got, err)
+			}
+		}
+	})
+}
+
+func TestNewPointAllFieldsOmitted(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	p, err := CommonArgs{}.newPoint("wx_agg", nil, map[string]any{"a": math.NaN(), "b": math.Inf(1)}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != nil {
+		t.Errorf("newPoint = %v, want nil", p)
+	}
+
+	p, err = CommonArgs{}.newPoint("wx_agg", nil, map[string]any{"a": math.NaN(), "b": 2.0}, now)
+	if err != nil || p == nil {
+		t.Fatalf("newPoint = %v, %v; want a point", p, err)
+	}
+	if fields, _ := p.Fields(); len(fields) != 1 || fields["b"] != 2.0 {
+		t.Errorf("fields = %v, want only b", fields)
+	}
+}
@@ -0,0 +1,190 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// NumericField names a source field and the statistics to compute over it.
+type NumericField struct {
+	Name  string
+	Stats []string // NumericStatMean, NumericStatMin, etc.
+}
+
+type NumericAggArgs struct {
+	CommonArgs
+	Store
+
+	Fields []NumericField
+}
+
+const (
+	NumericStatMean   = "mean"
+	NumericStatMin    = "min"
+	NumericStatMax    = "max"
+	NumericStatStdDev = "stddev"
+)
+
+func AllNumericStats() []string {
+	return []string{NumericStatMean, NumericStatMin, NumericStatMax, NumericStatStdDev}
+}
+
+// ParseNumericStats parses a comma-separated list of statistics, e.g. "mean,max".
+func ParseNumericStats(s string) ([]string, error) {
+	var retv []string
+	for _, stat := range strings.Split(s, ",") {
+		stat = strings.TrimSpace(stat)
+		switch stat {
+		case NumericStatMean, NumericStatMin, NumericStatMax, NumericStatStdDev:
+			retv = append(retv, stat)
+		default:
+			return nil, fmt.Errorf("unknown statistic '%s'; must be one of: %s", stat, strings.Join(AllNumericStats(), ", "))
+		}
+	}
+	return retv, nil
+}
+
+const (
+	numericInterval24h = "24h"
+	numericInterval1h  = "1h"
+)
+
+func allNumericIntervals() []string {
+	return []string{numericInterval24h, numericInterval1h}
+}
+
+func numericIntervalToDuration(interval string) time.Duration {
+	switch interval {
+	case numericInterval24h:
+		return 24 * time.Hour
+	case numericInterval1h:
+		return time.Hour
+	default:
+		panic(fmt.Sprintf("unknown numeric interval: %s", interval))
+	}
+}
+
+func numericResultFieldName(args NumericAggArgs, field, stat, interval string) string {
+	return args.intervalFieldName(field+"_"+stat, interval)
+}
+
+// numericStat computes the named statistic over values, which must not be empty.
+// stddev is the sample standard deviation, or 0 for a single value.
+func numericStat(stat string, values []float64) float64 {
+	switch stat {
+	case NumericStatMin:
+		retv := math.Inf(1)
+		for _, v := range values {
+			retv = math.Min(retv, v)
+		}
+		return retv
+	case NumericStatMax:
+		retv := math.Inf(-1)
+		for _, v := range values {
+			retv = math.Max(retv, v)
+		}
+		return retv
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if stat == NumericStatMean {
+		return mean
+	}
+	if len(values) < 2 {
+		return 0
+	}
+	sqDiffs := 0.0
+	for _, v := range values {
+		sqDiffs += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sqDiffs / float64(len(values)-1))
+}
+
+// NumericAgg computes the requested statistics over arbitrary numeric fields,
+// e.g. battery voltage or signal strength, which have no physical interpretation
+// beyond their values.
+func NumericAgg(ctx context.Context, args NumericAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	fieldNames := make([]string, len(args.Fields))
+	for i, f := range args.Fields {
+		fieldNames[i] = f.Name
+	}
+
+	// read every field in one query over the longest interval;
+	// shorter intervals will filter from this data.
+	samples, err := querySamples(ctx, args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       fieldNames,
+		SourceFields: args.SourceFields,
+		Window:       numericInterval24h,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		Summary:      args.Summary,
+	}, intervalDurations(allNumericIntervals(), numericIntervalToDuration)...))
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, args.emptyResult("no numeric data to aggregate")
+	}
+	args.Summary.AddSamplesRead(len(samples))
+	warnPassthroughIntervals("numeric", samples, allNumericIntervals()...)
+
+	latestTime := samples[len(samples)-1].t
+	var retv []*influxdb.Point
+
+	for _, interval := range allNumericIntervals() {
+		dur := numericIntervalToDuration(interval)
+		fields := make(map[string]interface{})
+
+		for i, field := range args.Fields {
+			var values []float64
+			for _, s := range samples {
+				if !args.inInterval(latestTime, s.t, dur) || math.IsNaN(s.values[i]) {
+					continue
+				}
+				values = append(values, s.values[i])
+			}
+			if len(values) == 0 || !args.enoughSamples(field.Name, interval, len(values)) {
+				continue
+			}
+			for _, stat := range field.Stats {
+				fields[numericResultFieldName(args, field.Name, stat, interval)] = numericStat(stat, values)
+			}
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		point, err := args.newPoint(
+			args.intervalMeasurement(interval),
+			args.WriteTags,
+			fields,
+			args.pointTime(args.intervalEnd(latestTime, dur), dur),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+		if point != nil {
+			retv = append(retv, point)
+		}
+		args.Summary.RecordIntervals("numeric", []string{interval})
+	}
+
+	return retv, nil
+}
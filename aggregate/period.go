@@ -0,0 +1,57 @@
+package aggregate
+
+import (
+	"fmt"
+	"time"
+)
+
+// Period is a named clock-aligned window over which the interval of the same
+// length is aggregated, in place of the window WindowType gives it. Like tumbling
+// windows, periods are aligned to UTC.
+type Period string
+
+const (
+	PeriodPreviousHour Period = "previous-hour" // the last complete clock hour; for the 1h interval
+	PeriodPreviousDay  Period = "previous-day"  // the last complete UTC day; for the 24h interval
+	PeriodCurrentDay   Period = "current-day"   // the UTC day so far; for the 24h interval
+)
+
+// Length returns the length of the interval the period replaces.
+func (p Period) Length() time.Duration {
+	switch p {
+	case PeriodPreviousHour:
+		return time.Hour
+	case PeriodPreviousDay, PeriodCurrentDay:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// window returns the period's window as of now. The current day's window ends at
+// the next midnight, so its aggregate keeps the same timestamp all day and each run
+// overwrites it.
+func (p Period) window(now time.Time) (start, end time.Time) {
+	if p == PeriodCurrentDay {
+		start = now.UTC().Truncate(24 * time.Hour)
+		return start, start.Add(24 * time.Hour)
+	}
+	return alignedWindow(now, p.Length())
+}
+
+// ParsePeriods parses a list of period names into a map from the length of the
+// interval each replaces to the period. At most one period may be given per length.
+func ParsePeriods(names []string) (map[time.Duration]Period, error) {
+	retv := make(map[time.Duration]Period, len(names))
+	for _, name := range names {
+		p := Period(name)
+		if p.Length() == 0 {
+			return nil, fmt.Errorf("unknown period '%s' (expected %s, %s, or %s)", name, PeriodPreviousHour, PeriodPreviousDay, PeriodCurrentDay)
+		}
+		if prev, ok := retv[p.Length()]; ok && prev != p {
+			return nil, fmt.Errorf("periods '%s' and '%s' both replace the %s interval", prev, p, intervalName(p.Length()))
+		}
+		retv[p.Length()] = p
+	}
+	return retv, nil
+}
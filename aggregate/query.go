@@ -0,0 +1,517 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// sample is a single row read from the source measurement.
+// values holds one entry per requested field, in the order requested;
+// a NaN entry means the field was not present in that row.
+type sample struct {
+	t      time.Time
+	values []float64
+}
+
+type sampleQuery struct {
+	Store
+
+	Measurement string
+	Fields      []string
+	// SourceFields optionally maps entries of Fields to differently-named source
+	// columns (e.g. a continuous query's "mean_wind_dir"). Mapped columns are read
+	// under an alias, so everything downstream sees the names in Fields.
+	SourceFields map[string]string
+	Window       string    // InfluxQL duration literal, e.g. "24h"; ignored if Since is set
+	Now          time.Time // the time Window ends at; if zero, the InfluxDB server's now()
+	Since        time.Time // if non-zero, read samples at or after this time instead of within Window
+	Until        time.Time // if non-zero, read only samples before this time
+	TagsWhere    string
+
+	// ExclusiveStart excludes a sample exactly Window before now, and Tolerance
+	// extends Window backward. Neither applies when Since is set.
+	ExclusiveStart bool
+	Tolerance      time.Duration
+
+	// Parsers optionally override toFloat for the given fields, e.g. to map a
+	// status string to a number.
+	Parsers map[string]func(v any) (float64, error)
+
+	Filter    *SampleFilter // if non-nil, applied to each field's values
+	MADExempt []string      // fields for which the MAD outlier filter makes no sense (e.g. circular or cumulative values)
+	Summary   *RunSummary
+}
+
+// querySamples reads all the given fields from the source measurement in a single
+// query covering the given window (plus one against the archive retention policy,
+// if configured), returning samples in ascending time order.
+// If a filter is given, values it rejects are treated as missing.
+// Rows in which none of the requested fields are present are skipped.
+func querySamples(ctx context.Context, sq sampleQuery) ([]sample, error) {
+	all, err := readSamples(ctx, sq, sq.InfluxRP)
+	if err != nil {
+		return nil, err
+	}
+	if sq.ArchiveRP != "" {
+		archived, err := readSamples(ctx, sq, sq.ArchiveRP)
+		if err != nil {
+			return nil, fmt.Errorf("reading archive retention policy: %w", err)
+		}
+		all = mergeArchivedSamples(all, archived)
+	}
+
+	if sq.Filter != nil {
+		for i, f := range sq.Fields {
+			dropped := sq.Filter.apply(f, all, i, !slices.Contains(sq.MADExempt, f))
+			if dropped > 0 {
+				log.Printf("dropped %d sentinel/outlier samples of %s", dropped, f)
+				sq.Summary.AddSamplesDropped(dropped)
+			}
+		}
+	}
+
+	retv := make([]sample, 0, len(all))
+	for _, s := range all {
+		if slices.ContainsFunc(s.values, func(v float64) bool { return !math.IsNaN(v) }) {
+			retv = append(retv, s)
+		}
+	}
+
+	return retv, nil
+}
+
+// singleSeries returns the only series in a query response, or nil if the response
+// is empty. It returns a *SchemaError if the response has more than one.
+func singleSeries(r *influxdb.Response) (*models.Row, error) {
+	if len(r.Results) == 0 || len(r.Results[0].Series) == 0 {
+		return nil, nil
+	}
+	if len(r.Results) > 1 {
+		return nil, &SchemaError{Msg: fmt.Sprintf("expected 1 result, got %d", len(r.Results))}
+	}
+	if len(r.Results[0].Series) > 1 {
+		return nil, &SchemaError{Msg: fmt.Sprintf("expected 1 series, got %d", len(r.Results[0].Series))}
+	}
+	return &r.Results[0].Series[0], nil
+}
+
+// columnIndex returns the index of the named column in a query result series.
+func columnIndex(series *models.Row, name string) (int, error) {
+	idx := slices.Index(series.Columns, name)
+	if idx < 0 {
+		return 0, &SchemaError{Msg: fmt.Sprintf("expected a '%s' column, got columns %v", name, series.Columns)}
+	}
+	return idx, nil
+}
+
+// toFloat converts a numeric value from a query result to a float64. Depending on
+// the client's decoding settings, numbers may arrive as json.Number, float64, or an
+// integer type; strings (including scientific notation, e.g. "1.5e3") are parsed.
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Float64()
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return 0, fmt.Errorf("unexpected value %v of type %T", v, v)
+	}
+}
+
+// tolerantToFloat is toFloat, but a string value which doesn't parse as a number is
+// retried as a locale-formatted one, e.g. "1,234.5", "1.234,5", "1 234,5", or
+// "1'234.5". Grouping separators (spaces, apostrophes, and whichever of '.' and ','
+// isn't the decimal separator) are removed. If both '.' and ',' appear, the last one
+// is the decimal separator; a lone ',' is one unless exactly three digits follow it
+// and a nonzero integer part precedes it, so "12,5" and "0,125" are 12.5 and 0.125,
+// but "1,234" is 1234.
+func tolerantToFloat(v interface{}) (float64, error) {
+	f, err := toFloat(v)
+	s, ok := v.(string)
+	if err == nil || !ok {
+		return f, err
+	}
+	s = strings.TrimSpace(s)
+	s = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "", "'", "", "\u2019", "").Replace(s)
+	decimal := byte(0)
+	lastDot, lastComma := strings.LastIndexByte(s, '.'), strings.LastIndexByte(s, ',')
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		decimal = s[max(lastDot, lastComma)]
+	case lastComma >= 0 && strings.Count(s, ",") == 1:
+		// grouping never follows an empty or zero leading group:
+		intPart := strings.TrimLeft(s[:lastComma], "+-")
+		if len(s)-lastComma-1 != 3 || intPart == "" || intPart[0] == '0' {
+			decimal = ','
+		}
+	case lastDot >= 0 && strings.Count(s, ".") == 1:
+		decimal = '.'
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == decimal:
+			b.WriteByte('.')
+		case c == '.' || c == ',':
+			// a grouping separator
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if f, tolerantErr := strconv.ParseFloat(b.String(), 64); tolerantErr == nil {
+		return f, nil
+	}
+	return 0, err
+}
+
+// readEpochUnits maps each supported Store.ReadEpoch to the duration of one unit.
+var readEpochUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// ValidateReadEpoch returns an error if the given epoch precision isn't empty (for
+// RFC3339 timestamps) or one supported as a Store.ReadEpoch.
+func ValidateReadEpoch(epoch string) error {
+	if _, ok := readEpochUnits[epoch]; epoch != "" && !ok {
+		return fmt.Errorf("read-epoch must be ns, u, ms, or s; got '%s'", epoch)
+	}
+	return nil
+}
+
+// parseTimeValue parses a timestamp value from a query result: an RFC3339 string,
+// or an integer in the store's ReadEpoch units.
+func (s Store) parseTimeValue(v any) (time.Time, error) {
+	var n int64
+	switch v := v.(type) {
+	case string:
+		return time.Parse(time.RFC3339, v)
+	case json.Number:
+		var err error
+		if n, err = v.Int64(); err != nil {
+			return time.Time{}, fmt.Errorf("expected an integer epoch time, got %v", v)
+		}
+	case int64:
+		n = v
+	case float64:
+		n = int64(v)
+	default:
+		return time.Time{}, fmt.Errorf("expected an RFC3339 or epoch time, got %v", v)
+	}
+	unit, ok := readEpochUnits[s.ReadEpoch]
+	if !ok {
+		return time.Time{}, fmt.Errorf("got epoch time %d, but no read epoch precision is set", n)
+	}
+	return time.Unix(0, n*int64(unit)).UTC(), nil
+}
+
+// timeLiteral formats t as an InfluxQL time literal.
+func timeLiteral(t time.Time) string {
+	return "'" + t.UTC().Format(time.RFC3339Nano) + "'"
+}
+
+// timeWhere returns the condition on time selecting the query's window.
+func (sq sampleQuery) timeWhere() string {
+	op := ">="
+	if sq.ExclusiveStart {
+		op = ">"
+	}
+	ref := "now()"
+	if !sq.Now.IsZero() {
+		ref = timeLiteral(sq.Now)
+	}
+	timeWhere := fmt.Sprintf("time %s %s-%s", op, ref, sq.Window)
+	if sq.Tolerance > 0 {
+		// rounded up, so that the query covers at least the tolerance:
+		timeWhere += fmt.Sprintf("-%dms", (sq.Tolerance+time.Millisecond-1)/time.Millisecond)
+	}
+	if !sq.Since.IsZero() {
+		timeWhere = fmt.Sprintf("time >= '%s'", sq.Since.Format(time.RFC3339))
+	}
+	if !sq.Until.IsZero() {
+		timeWhere += fmt.Sprintf(" AND time < '%s'", sq.Until.Format(time.RFC3339))
+	}
+	return timeWhere
+}
+
+// sourceStore returns the store source data is read from, in the given retention
+// policy.
+func (sq sampleQuery) sourceStore(rp string) Store {
+	store := sq.Store
+	store.InfluxRP = rp
+	if store.SourceDB != "" {
+		store.InfluxDB = store.SourceDB
+	}
+	return store
+}
+
+// sourceHasRows reports whether the source measurement has any rows in the query's
+// window, with any fields, in its primary retention policy. It distinguishes a
+// station which reported none of the queried fields from one which didn't report.
+func sourceHasRows(ctx context.Context, sq sampleQuery) (bool, error) {
+	timeWhere := sq.timeWhere()
+	q := fmt.Sprintf("SELECT * FROM %s WHERE %s %s LIMIT 1", sq.Measurement, timeWhere, sq.TagsWhere)
+	if sq.SourceQuery != "" {
+		q = expandSourceQuery(sq.SourceQuery, "*", sq.Measurement, timeWhere, sq.TagsWhere)
+	}
+	r, err := runQuery(ctx, sq.sourceStore(sq.InfluxRP), q)
+	if err != nil {
+		return false, err
+	}
+	for _, res := range r.Results {
+		for _, series := range res.Series {
+			if len(series.Values) > 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// readSamples runs a sampleQuery against the given retention policy, returning
+// its rows, unfiltered, in ascending time order.
+func readSamples(ctx context.Context, sq sampleQuery, rp string) ([]sample, error) {
+	timeWhere := sq.timeWhere()
+	selects := make([]string, len(sq.Fields))
+	for i, f := range sq.Fields {
+		selects[i] = f
+		if src, ok := sq.SourceFields[f]; ok && src != f {
+			selects[i] = fmt.Sprintf(`"%s" AS "%s"`, src, f)
+		}
+	}
+	q := fmt.Sprintf("SELECT time, %s FROM %s WHERE %s %s ORDER BY time ASC",
+		strings.Join(selects, ", "), sq.Measurement, timeWhere, sq.TagsWhere)
+	if sq.SourceQuery != "" {
+		q = expandSourceQuery(sq.SourceQuery, strings.Join(selects, ", "), sq.Measurement, timeWhere, sq.TagsWhere)
+	}
+	store := sq.sourceStore(rp)
+	var all []sample
+	var nSeries int
+	if store.ChunkSize > 0 {
+		var err error
+		if all, nSeries, err = readChunkedSamples(ctx, sq, store, q); err != nil {
+			return nil, err
+		}
+	} else {
+		r, err := runQuery(ctx, store, q)
+		if err != nil {
+			return nil, err
+		}
+		seriesList, err := sourceSeries(r, sq.MultiSeries == MultiSeriesMerge)
+		if err != nil {
+			return nil, err
+		}
+		for _, series := range seriesList {
+			samples, err := seriesSamples(sq, series)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, samples...)
+		}
+		nSeries = len(seriesList)
+	}
+	if sq.SourceQuery != "" || nSeries > 1 {
+		// a custom query might not order its results, and merged series are each ordered separately:
+		slices.SortStableFunc(all, func(a, b sample) int { return a.t.Compare(b.t) })
+	}
+	return all, nil
+}
+
+// readChunkedSamples runs the source query q with a chunked response, parsing each
+// chunk's rows into samples as it arrives so the whole response is never held in
+// memory. A series may span several chunks; each chunk carries its own columns, so
+// they're resolved per chunk. It also returns the number of distinct series read,
+// which, as with sourceSeries, must be 1 unless series are merged.
+func readChunkedSamples(ctx context.Context, sq sampleQuery, store Store, q string) ([]sample, int, error) {
+	var all []sample
+	var tagSets []string
+	err := runChunkedQuery(ctx, store, q, func(r *influxdb.Response) error {
+		if len(r.Results) > 1 {
+			return &SchemaError{Msg: fmt.Sprintf("expected 1 result, got %d", len(r.Results))}
+		}
+		for _, res := range r.Results {
+			for _, series := range res.Series {
+				key := series.Name + fmt.Sprintf("%v", series.Tags)
+				if !slices.Contains(tagSets, key) {
+					tagSets = append(tagSets, key)
+				}
+				samples, err := seriesSamples(sq, series)
+				if err != nil {
+					return err
+				}
+				all = append(all, samples...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(tagSets) > 1 && sq.MultiSeries != MultiSeriesMerge {
+		return nil, 0, &SchemaError{Msg: fmt.Sprintf(
+			"expected 1 series from the source, got %d (series: %s); narrow the query with -tags or -filter, or combine them with -multi-series merge",
+			len(tagSets), strings.Join(tagSets, ", "))}
+	}
+	return all, len(tagSets), nil
+}
+
+// sourceSeries returns the series in a source query response. Without merge, more
+// than one series is a *SchemaError, since it means the query didn't narrow the
+// source to a single station, e.g. because the data has tags not given in -tags.
+func sourceSeries(r *influxdb.Response, merge bool) ([]models.Row, error) {
+	if !merge {
+		series, err := singleSeries(r)
+		if err != nil {
+			var schemaErr *SchemaError
+			if errors.As(err, &schemaErr) && len(r.Results) == 1 {
+				tagSets := make([]string, len(r.Results[0].Series))
+				for i, s := range r.Results[0].Series {
+					tagSets[i] = fmt.Sprintf("%v", s.Tags)
+				}
+				return nil, &SchemaError{Msg: fmt.Sprintf(
+					"expected 1 series from the source, got %d (tags: %s); narrow the query with -tags or -filter, or combine them with -multi-series merge",
+					len(tagSets), strings.Join(tagSets, ", "))}
+			}
+			return nil, err
+		}
+		if series == nil {
+			return nil, nil
+		}
+		return []models.Row{*series}, nil
+	}
+	if len(r.Results) == 0 {
+		return nil, nil
+	}
+	if len(r.Results) > 1 {
+		return nil, &SchemaError{Msg: fmt.Sprintf("expected 1 result, got %d", len(r.Results))}
+	}
+	return r.Results[0].Series, nil
+}
+
+// seriesSamples parses the rows of one source series into samples.
+func seriesSamples(sq sampleQuery, series models.Row) ([]sample, error) {
+	timeIdx, err := columnIndex(&series, sq.timeColumn())
+	if err != nil {
+		return nil, err
+	}
+	fieldIdx := make([]int, len(sq.Fields))
+	for i, f := range sq.Fields {
+		if fieldIdx[i] = slices.Index(series.Columns, f); fieldIdx[i] < 0 {
+			return nil, &SchemaError{Msg: fmt.Sprintf("expected a '%s' column, got columns %v", f, series.Columns), Field: f}
+		}
+	}
+
+	retv := make([]sample, 0, len(series.Values))
+	for _, row := range series.Values {
+		s := sample{values: make([]float64, len(sq.Fields))}
+		for i, f := range sq.Fields {
+			if row[fieldIdx[i]] == nil {
+				s.values[i] = math.NaN()
+				continue
+			}
+			parse := toFloat
+			if sq.TolerantParse {
+				parse = tolerantToFloat
+			}
+			if p, ok := sq.Parsers[f]; ok {
+				parse = p
+			}
+			v, err := parse(row[fieldIdx[i]])
+			if err != nil {
+				return nil, &ParseError{What: f, Err: err}
+			}
+			s.values[i] = v
+		}
+		s.t, err = sq.parseTimeValue(row[timeIdx])
+		if err != nil {
+			return nil, &ParseError{What: "timestamp", Err: err}
+		}
+		retv = append(retv, s)
+	}
+	return retv, nil
+}
+
+// Placeholders in a -source-query template. Every template must include the
+// required ones, so that each aggregation reads only its window and its station.
+const (
+	sourceQueryTimeFilter  = "$timeFilter"  // required; a condition on time, e.g. "time >= '2024-05-01T13:00:00Z'-24h"
+	sourceQueryTags        = "$tags"        // required; " AND " followed by tag and -filter conditions, or empty
+	sourceQueryFields      = "$fields"      // the fields the aggregation needs, e.g. "wind_dir, wind_speed"
+	sourceQueryMeasurement = "$measurement" // the source measurement
+)
+
+// ValidateSourceQuery checks that a -source-query template includes the required placeholders.
+func ValidateSourceQuery(tmpl string) error {
+	for _, p := range []string{sourceQueryTimeFilter, sourceQueryTags} {
+		if !strings.Contains(tmpl, p) {
+			return fmt.Errorf("source-query must include the %s placeholder", p)
+		}
+	}
+	return nil
+}
+
+// expandSourceQuery fills in a -source-query template's placeholders. Whatever the
+// template selects, results are read by column name, so it must return a column
+// named for each field the aggregation needs (via $fields, or aliases).
+func expandSourceQuery(tmpl, fields, measurement, timeWhere, tagsWhere string) string {
+	return strings.NewReplacer(
+		sourceQueryTimeFilter, timeWhere,
+		sourceQueryTags, tagsWhere,
+		sourceQueryFields, fields,
+		sourceQueryMeasurement, measurement,
+	).Replace(tmpl)
+}
+
+// mergeArchivedSamples combines samples from the primary retention policy with
+// older samples from an archive retention policy. Primary samples always win: an
+// archived sample is used only if it is older than the oldest primary sample, so
+// there is no double-counting where the two retention policies overlap.
+func mergeArchivedSamples(primary, archived []sample) []sample {
+	if len(archived) == 0 {
+		return primary
+	}
+	if len(primary) == 0 {
+		return archived
+	}
+	boundary := primary[0].t
+	idx, _ := slices.BinarySearchFunc(archived, boundary, func(s sample, t time.Time) int {
+		return s.t.Compare(t)
+	})
+	return append(archived[:idx:idx], primary...)
+}
+
+// PartialWhereClauseForTags returns an InfluxQL condition matching the given tags,
+// prefixed with " AND " for appending to a WHERE clause, or "" if there are none.
+func PartialWhereClauseForTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	var parts []string
+	for k, v := range tags {
+		parts = append(parts, fmt.Sprintf(`%s='%s'`, k, v))
+	}
+	return " AND " + strings.Join(parts, " AND ")
+}
@@ -0,0 +1,85 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToFloat(t *testing.T) {
+	tests := []struct {
+		v       any
+		want    float64
+		wantErr bool
+	}{
+		{json.Number("12.5"), 12.5, false},
+		{json.Number("-3"), -3, false},
+		{json.Number("1.5e3"), 1500, false},
+		{json.Number("2E-2"), 0.02, false},
+		{json.Number("abc"), 0, true},
+		{float64(12.5), 12.5, false},
+		{float32(0.25), 0.25, false},
+		{int64(-7), -7, false},
+		{int(42), 42, false},
+		{uint64(18446744073709551615), 18446744073709551615, false},
+		{"12.5", 12.5, false},
+		{" 12.5\n", 12.5, false},
+		{"-1e-3", -0.001, false},
+		{"6.02E23", 6.02e23, false},
+		{"", 0, true},
+		{"12,5", 0, true},
+		{"n/a", 0, true},
+		{true, 0, true},
+		{nil, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := toFloat(tt.v)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("toFloat(%#v) error = %v, wantErr %v", tt.v, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("toFloat(%#v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestTolerantToFloat(t *testing.T) {
+	tests := []struct {
+		v       any
+		want    float64
+		wantErr bool
+	}{
+		{"12.5", 12.5, false},
+		{"1.234", 1.234, false},
+		{"12,5", 12.5, false},
+		{"0,125", 0.125, false},
+		{"-0,125", -0.125, false},
+		{",125", 0.125, false},
+		{"00,125", 0.125, false},
+		{"1,234", 1234, false},
+		{"-1,234", -1234, false},
+		{"1,2345", 1.2345, false},
+		{"1,234.5", 1234.5, false},
+		{"1.234,5", 1234.5, false},
+		{"1,234,567", 1234567, false},
+		{"1.234.567", 1234567, false},
+		{"1 234,5", 1234.5, false},
+		{"1\u00a0234,5", 1234.5, false},
+		{"1'234.5", 1234.5, false},
+		{" 0,5 ", 0.5, false},
+		{json.Number("3"), 3, false},
+		{"n/a", 0, true},
+		{"1,2,3x", 0, true},
+		{true, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := tolerantToFloat(tt.v)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("tolerantToFloat(%#v) error = %v, wantErr %v", tt.v, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("tolerantToFloat(%#v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
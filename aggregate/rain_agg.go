@@ -0,0 +1,427 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type RainAggArgs struct {
+	CommonArgs
+	Store
+
+	RainField    string
+	OutputPrefix string // prefix for output field names; defaults to RainField
+
+	// BootstrapLookback, if positive, is how far back into source history the
+	// event total is reconstructed when no previous event total exists (i.e. on the
+	// first run against a new target). If zero, the first run's event total is the
+	// 24h total.
+	BootstrapLookback time.Duration
+
+	// ResetThreshold (mm) separates gauge noise from counter resets: a decrease no
+	// larger than this is noise, and a larger one is a reset. Defaults to
+	// DefaultRainResetThreshold.
+	ResetThreshold float64
+}
+
+const (
+	rainInterval24h = "24h"
+	rainInterval1h  = "1h"
+
+	rainEventResetThreshold = 1.0 // mm in 24h to keep an event active
+
+	DefaultRainResetThreshold = 0.5 // mm
+)
+
+func allRainIntervals() []string {
+	return []string{rainInterval24h, rainInterval1h}
+}
+
+func rainIntervalToDuration(interval string) time.Duration {
+	switch interval {
+	case rainInterval24h:
+		return 24 * time.Hour
+	case rainInterval1h:
+		return time.Hour
+	default:
+		panic(fmt.Sprintf("unknown rain interval: %s", interval))
+	}
+}
+
+func rainOutputPrefix(args RainAggArgs) string {
+	if args.OutputPrefix != "" {
+		return args.OutputPrefix
+	}
+	return args.RainField
+}
+
+func rainResultFieldName(args RainAggArgs, interval string) string {
+	return args.intervalFieldName(rainOutputPrefix(args), interval)
+}
+
+func rainRateFieldName(args RainAggArgs) string {
+	return rainOutputPrefix(args) + "_rate" + args.FieldSuffix
+}
+
+func rainEventFieldName(args RainAggArgs) string {
+	return rainOutputPrefix(args) + "_event" + args.FieldSuffix
+}
+
+// FieldUnits returns the units of this aggregation's output fields which have one.
+// The event total is omitted: it's read back by the next run, so it must keep its
+// name.
+func (args RainAggArgs) FieldUnits() FieldUnits {
+	prefix := rainOutputPrefix(args)
+	return FieldUnits{prefix: UnitMm, prefix + "_rate": UnitMmH}
+}
+
+type rainDataPoint struct {
+	t    time.Time
+	rain float64
+}
+
+func rainResetThreshold(args RainAggArgs) float64 {
+	if args.ResetThreshold > 0 {
+		return args.ResetThreshold
+	}
+	return DefaultRainResetThreshold
+}
+
+// accumRain calculates total rainfall from a series of cumulative gauge readings.
+//
+// A decrease of at most resetThreshold is treated as noise: it contributes nothing,
+// and the gauge must climb back above the previous reading before rain is counted
+// again. A larger decrease is a counter reset; rain before the reset has already been
+// counted, and if the new reading is near zero (at most resetThreshold), it is counted
+// as rain since the reset. A large drop to a value far from zero is a glitch or a
+// re-based counter: the higher baseline is kept until the next reading, and only if
+// that reading is still well below the baseline is the counter taken to have been
+// re-based, resuming from the low reading. A one-reading glitch (e.g. 10, 3, 10)
+// therefore counts no rain.
+func accumRain(data []rainDataPoint, resetThreshold float64) float64 {
+	total := 0.0
+	for _, inc := range rainIncrements(data, resetThreshold) {
+		total += inc
+	}
+	return total
+}
+
+// rainIncrements returns the rain counted at each of a series of cumulative gauge
+// readings, following the same rules as accumRain. The first reading is the
+// baseline, so its increment is always 0.
+func rainIncrements(data []rainDataPoint, resetThreshold float64) []float64 {
+	retv := make([]float64, len(data))
+	prev := math.NaN()
+	var dropped *rainDataPoint // a large drop not yet confirmed by a later reading
+	for i, dp := range data {
+		if math.IsNaN(prev) {
+			prev = dp.rain
+			continue
+		}
+		if dropped != nil {
+			if prev-dp.rain > resetThreshold {
+				log.Printf("WARNING: rain gauge dropped from %.2f to %.2f at %s; resuming from the new reading", prev, dropped.rain, dropped.t.Format(time.RFC3339))
+				prev = dropped.rain
+			} else {
+				log.Printf("WARNING: ignoring rain gauge glitch at %s (%.2f -> %.2f)", dropped.t.Format(time.RFC3339), prev, dropped.rain)
+			}
+			dropped = nil
+		}
+		delta := dp.rain - prev
+		switch {
+		case delta >= 0:
+			retv[i] = delta
+		case -delta <= resetThreshold:
+			continue // noise; keep the higher reading as the baseline
+		case dp.rain <= resetThreshold:
+			log.Printf("rain gauge reset detected at %s (%.2f -> %.2f)", dp.t.Format(time.RFC3339), prev, dp.rain)
+			retv[i] = math.Max(dp.rain, 0)
+		default:
+			dropped = &data[i]
+			continue // keep the higher baseline until the next reading confirms the drop
+		}
+		prev = dp.rain
+	}
+	return retv
+}
+
+func RainAgg(ctx context.Context, args RainAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	// query for the longest interval; shorter intervals will filter from this data.
+	sq := args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       []string{args.RainField},
+		SourceFields: args.SourceFields,
+		Window:       rainInterval24h,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		MADExempt:    []string{args.RainField}, // cumulative counter
+		Summary:      args.Summary,
+	}, intervalDurations(allRainIntervals(), rainIntervalToDuration)...)
+	sq.Until = time.Time{} // the rain rate and event total are always as of the latest sample
+	samples, err := querySamples(ctx, sq)
+	if err != nil {
+		return nil, err
+	}
+
+	var allData []rainDataPoint
+	for _, s := range samples {
+		allData = append(allData, rainDataPoint{t: s.t, rain: s.values[0]})
+	}
+
+	if len(allData) == 0 {
+		return nil, args.emptyResult("no rain data to aggregate")
+	}
+
+	args.Summary.AddSamplesRead(len(allData))
+	warnPassthroughIntervals(rainOutputPrefix(args), samples, allRainIntervals()...)
+
+	latestTime := allData[len(allData)-1].t
+	var retv []*influxdb.Point
+
+	// rain totals per interval:
+	var rain24h float64
+	have24h := false
+	for _, interval := range allRainIntervals() {
+		dur := rainIntervalToDuration(interval)
+
+		// filter data points for this interval:
+		var intervalData []rainDataPoint
+		for _, dp := range allData {
+			if args.inInterval(latestTime, dp.t, dur) {
+				intervalData = append(intervalData, dp)
+			}
+		}
+
+		if len(intervalData) == 0 || !args.enoughSamples(rainOutputPrefix(args), interval, len(intervalData)) {
+			continue
+		}
+
+		rainTotal := accumRain(intervalData, rainResetThreshold(args))
+		if interval == rainInterval24h {
+			rain24h, have24h = rainTotal, true
+		}
+
+		p, err := args.newPoint(
+			args.intervalMeasurement(interval),
+			args.WriteTags,
+			map[string]any{
+				rainResultFieldName(args, interval): rainTotal,
+			},
+			args.pointTime(args.intervalEnd(intervalData[len(intervalData)-1].t, dur), dur),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+		if p != nil {
+			retv = append(retv, p)
+		}
+		args.Summary.RecordIntervals(rainOutputPrefix(args), []string{interval})
+	}
+
+	// rain rate (rain over past 10 minutes, extrapolated to per-hour).
+	// like the totals, it's timestamped within the 10-minute window per the
+	// configured strategy (by default, its end).
+	var rateData []rainDataPoint
+	for _, dp := range allData {
+		if latestTime.Sub(dp.t) <= 10*time.Minute {
+			rateData = append(rateData, dp)
+		}
+	}
+	if len(rateData) > 0 && args.enoughSamples(rainOutputPrefix(args)+" rate", "10m", len(rateData)) {
+		p, err := args.newPoint(
+			args.MeasurementTo,
+			args.WriteTags,
+			map[string]any{
+				rainRateFieldName(args): accumRain(rateData, rainResetThreshold(args)) * 6,
+			},
+			args.pointTime(latestTime, 10*time.Minute),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+		if p != nil {
+			retv = append(retv, p)
+		}
+	}
+
+	// event rainfall (continuous rain; resets when 24h total < 1mm). without a 24h
+	// total, e.g. because -min-samples skipped that interval, there's no telling
+	// whether the event has ended, so the stored event total is left untouched:
+	if !have24h {
+		log.Printf("no 24h rain total; leaving %s unchanged", rainEventFieldName(args))
+		return retv, nil
+	}
+	eventTotal, err := rainEventAgg(ctx, args, tagsWhere, rain24h)
+	if err != nil {
+		return nil, fmt.Errorf("rain event aggregation failed: %w", err)
+	}
+	p, err := args.newPoint(
+		args.MeasurementTo,
+		args.WriteTags,
+		map[string]any{
+			rainEventFieldName(args): eventTotal,
+		},
+		latestTime,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+	}
+	if p != nil {
+		retv = append(retv, p)
+	}
+
+	return retv, nil
+}
+
+func rainEventAgg(ctx context.Context, args RainAggArgs, tagsWhere string, rain24h float64) (float64, error) {
+	if rain24h < rainEventResetThreshold {
+		return 0, nil
+	}
+
+	// read the previous event total from the agg measurement:
+	eventField := rainEventFieldName(args)
+	q := fmt.Sprintf("SELECT time, %s FROM %s WHERE time > 0 %s ORDER BY time DESC LIMIT 1",
+		eventField, args.MeasurementTo, tagsWhere)
+	r, err := runQuery(ctx, args.Store, q)
+	if err != nil {
+		return 0, err
+	}
+	series, err := singleSeries(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// if no previous event total exists, this is the first run against this target;
+	// bootstrap the event from source history if configured, or else fall back to
+	// the 24h total:
+	if series == nil {
+		if args.BootstrapLookback > 0 {
+			return bootstrapRainEvent(ctx, args, tagsWhere)
+		}
+		return rain24h, nil
+	}
+
+	timeIdx, err := columnIndex(series, args.timeColumn())
+	if err != nil {
+		return 0, err
+	}
+	eventIdx, err := columnIndex(series, eventField)
+	if err != nil {
+		return 0, err
+	}
+
+	prevEventTotal := 0.0
+
+	if series.Values[0][eventIdx] != nil {
+		prevEventTotal, err = toFloat(series.Values[0][eventIdx])
+		if err != nil {
+			return 0, &ParseError{What: "previous event total", Err: err}
+		}
+	}
+	prevEventTime, err := args.parseTimeValue(series.Values[0][timeIdx])
+	if err != nil {
+		return 0, &ParseError{What: "previous event time", Err: err}
+	}
+
+	// if the previous event value was a reset (0), use 24h total as the new event total:
+	if prevEventTotal == 0 {
+		return rain24h, nil
+	}
+
+	// query raw rain data since the previous event agg to calculate new rain.
+	// use >= so the data point at prevEventTime is included as the baseline for
+	// accumRain; otherwise the delta between that point and the next one is lost
+	// each cycle, causing the event total to drift below the true total.
+	samples, err := querySamples(ctx, sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       []string{args.RainField},
+		SourceFields: args.SourceFields,
+		Since:        prevEventTime,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		MADExempt:    []string{args.RainField},
+		Summary:      args.Summary,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(samples) == 0 {
+		return prevEventTotal, nil
+	}
+
+	var newData []rainDataPoint
+	for _, s := range samples {
+		newData = append(newData, rainDataPoint{t: s.t, rain: s.values[0]})
+	}
+	args.Summary.AddSamplesRead(len(newData))
+
+	return prevEventTotal + accumRain(newData, rainResetThreshold(args)), nil
+}
+
+// bootstrapRainEvent reconstructs the current event total from up to
+// args.BootstrapLookback of source history, replaying the per-run event rules
+// at every source sample: the event resets whenever less than
+// rainEventResetThreshold fell in the trailing 24h, starts from the 24h total
+// once that threshold is reached, and accumulates from there.
+//
+// The oldest 24h of history has an incomplete trailing window, so an event
+// already under way when the history begins may be undercounted.
+func bootstrapRainEvent(ctx context.Context, args RainAggArgs, tagsWhere string) (float64, error) {
+	samples, err := querySamples(ctx, sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       []string{args.RainField},
+		SourceFields: args.SourceFields,
+		Since:        args.now().Add(-args.BootstrapLookback),
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		MADExempt:    []string{args.RainField},
+		Summary:      args.Summary,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	data := make([]rainDataPoint, len(samples))
+	for i, s := range samples {
+		data[i] = rainDataPoint{t: s.t, rain: s.values[0]}
+	}
+	// not counted in the summary's samples read, since RainAgg already counted the
+	// past 24h of them
+	incs := rainIncrements(data, rainResetThreshold(args))
+
+	event := 0.0
+	trailing := 0.0 // rain in (data[i].t-24h, data[i].t]
+	oldest := 0
+	for i, dp := range data {
+		trailing += incs[i]
+		for !data[oldest].t.After(dp.t.Add(-24 * time.Hour)) {
+			trailing -= incs[oldest]
+			oldest++
+		}
+		switch {
+		case trailing < rainEventResetThreshold:
+			event = 0
+		case event == 0:
+			event = trailing
+		default:
+			event += incs[i]
+		}
+	}
+
+	log.Printf("bootstrapped %s from %d samples over the past %s: %.2f mm",
+		rainEventFieldName(args), len(data), args.BootstrapLookback, event)
+	return event, nil
+}
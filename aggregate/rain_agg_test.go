@@ -0,0 +1,92 @@
+package aggregate
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+func TestAccumRain(t *testing.T) {
+	tests := []struct {
+		name     string
+		readings []float64
+		want     float64
+	}{
+		{"steady rain", []float64{0, 1, 2.5, 4}, 4},
+		{"no rain", []float64{3, 3, 3}, 0},
+		{"noise keeps the higher baseline", []float64{5, 4.8, 5, 5.5}, 0.5},
+		{"reset to zero", []float64{10, 12, 0, 1}, 3},
+		{"reset to a small reading", []float64{10, 12, 0.3, 1}, 3},
+		{"one-reading glitch", []float64{10, 3, 10}, 0},
+		{"glitch then rain", []float64{10, 3, 11}, 1},
+		{"glitch at the last reading", []float64{10, 11, 3}, 1},
+		{"re-based counter", []float64{10, 3, 3.5, 4}, 1},
+		{"re-based counter, then a second drop", []float64{10, 6, 6.5, 2, 2, 2.5}, 1},
+		{"single reading", []float64{7}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+			data := make([]rainDataPoint, len(tt.readings))
+			for i, r := range tt.readings {
+				data[i] = rainDataPoint{t: start.Add(time.Duration(i) * time.Minute), rain: r}
+			}
+			if got := accumRain(data, DefaultRainResetThreshold); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("accumRain(%v) = %v, want %v", tt.readings, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRainAggLeavesEventWithout24hTotal(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		minSamples int
+		wantEvent  bool
+	}{
+		{"24h total", 2, true},
+		{"24h skipped by -min-samples", 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &scriptedClient{rows: map[string]models.Row{
+				"SELECT time, rain FROM": samplesRow("rain", now, 1, 2, 3),
+			}}
+			points, err := RainAgg(context.Background(), RainAggArgs{
+				CommonArgs: CommonArgs{
+					MeasurementFrom: "wx",
+					MeasurementTo:   "wx_agg",
+					MinSamples:      tt.minSamples,
+					Now:             func() time.Time { return now },
+				},
+				Store:     Store{Influx: client},
+				RainField: "rain",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotEvent := false
+			for _, p := range points {
+				fields, _ := p.Fields()
+				if _, ok := fields["rain_event"]; ok {
+					gotEvent = true
+				}
+			}
+			if gotEvent != tt.wantEvent {
+				t.Errorf("wrote rain_event: %v, want %v", gotEvent, tt.wantEvent)
+			}
+			if !tt.wantEvent {
+				for _, c := range client.commands {
+					if strings.Contains(c, "rain_event") {
+						t.Errorf("read the stored event total: %s", c)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Rollup computes a statistic over the stored values of another aggregate over a
+// longer interval, e.g. the 24h mean of hourly means, which is cheaper than
+// re-reading a day of raw data.
+type Rollup struct {
+	Input         string // the input aggregate field's name, without its interval or FieldSuffix, e.g. "temp_mean"
+	InputInterval string // the input aggregate's interval, e.g. "1h"
+	Stat          string // NumericStatMean, NumericStatMin, etc.
+	Interval      string // the interval to roll up over, e.g. "24h"
+}
+
+// ParseRollup parses a rollup of the form <input>_<input interval>:<stat>:<interval>,
+// e.g. "temp_mean_1h:mean:24h".
+func ParseRollup(s string) (Rollup, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return Rollup{}, fmt.Errorf("expected <field>_<interval>:<stat>:<interval>, got '%s'", s)
+	}
+	input, inputDur := splitIntervalFieldName(strings.TrimSpace(parts[0]))
+	if inputDur <= 0 || input == "" {
+		return Rollup{}, fmt.Errorf("input '%s' must be an aggregate field name ending in its interval, e.g. temp_mean_1h", parts[0])
+	}
+	stats, err := ParseNumericStats(parts[1])
+	if err != nil {
+		return Rollup{}, err
+	}
+	if len(stats) != 1 {
+		return Rollup{}, fmt.Errorf("expected one statistic, got '%s'", parts[1])
+	}
+	interval := strings.TrimSpace(parts[2])
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return Rollup{}, fmt.Errorf("invalid interval '%s': %w", interval, err)
+	}
+	if d <= inputDur {
+		return Rollup{}, fmt.Errorf("interval %s must be longer than the input's interval %s", interval, intervalName(inputDur))
+	}
+	return Rollup{
+		Input:         input,
+		InputInterval: strings.TrimSpace(parts[0])[len(input)+1:],
+		Stat:          stats[0],
+		Interval:      interval,
+	}, nil
+}
+
+func (r Rollup) String() string {
+	return fmt.Sprintf("%s_%s:%s:%s", r.Input, r.InputInterval, r.Stat, r.Interval)
+}
+
+func (r Rollup) inputDuration() time.Duration {
+	d, _ := time.ParseDuration(r.InputInterval)
+	return d
+}
+
+func (r Rollup) duration() time.Duration {
+	d, _ := time.ParseDuration(r.Interval)
+	return d
+}
+
+type RollupAggArgs struct {
+	CommonArgs // SourceFilter, SourceFields, and Filter are unused
+	Store
+
+	Rollups []Rollup
+}
+
+func rollupResultFieldName(args RollupAggArgs, r Rollup) string {
+	return args.intervalFieldName(r.Input+"_"+r.InputInterval+"_"+r.Stat, r.Interval)
+}
+
+// RollupAgg computes each of the given rollups from its input aggregate's values
+// stored in MeasurementTo, plus any among computed, the points computed earlier in
+// this run, which haven't been written yet. Computed values replace stored ones with
+// the same timestamp. Rollups are computed in order, and each one's output is
+// available as input to those after it.
+//
+// An input value belongs to a rollup interval if the midpoint of the window it
+// summarizes does, regardless of TimestampStrategy. A sliding interval ends at the
+// end of the newest input's window. Inputs whose windows overlap, as sliding
+// windows stored every run do, count once: the newest is kept, and each older one
+// only if its window ends before the last kept one's begins.
+func RollupAgg(ctx context.Context, args RollupAggArgs, computed []*influxdb.Point) ([]*influxdb.Point, error) {
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	// stored aggregates are read back from the destination, like freshness checks:
+	store := args.Store
+	store.SourceDB, store.ArchiveRP, store.SourceQuery = "", "", ""
+
+	var retv []*influxdb.Point
+	for _, r := range args.Rollups {
+		inDur, dur := r.inputDuration(), r.duration()
+		inputMeasurement := args.intervalMeasurement(r.InputInterval)
+		inputField := args.intervalFieldName(r.Input, r.InputInterval)
+
+		// an input's timestamp may be up to its interval outside the window its
+		// midpoint belongs to:
+		sq := args.alignQuery(sampleQuery{
+			Store:       store,
+			Measurement: inputMeasurement,
+			Fields:      []string{inputField},
+			Window:      fmt.Sprintf("%ds", int64((dur+inDur)/time.Second)),
+			TagsWhere:   tagsWhere,
+		}, dur)
+		if !sq.Since.IsZero() {
+			sq.Since = sq.Since.Add(-inDur)
+		}
+		if !sq.Until.IsZero() {
+			sq.Until = sq.Until.Add(inDur)
+		}
+		samples, err := querySamples(ctx, sq)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[int64]float64, len(samples)) // by timestamp, in Unix ns
+		for _, s := range samples {
+			values[s.t.UnixNano()] = s.values[0]
+		}
+		for _, p := range slices.Concat(computed, retv) {
+			if p.Name() != inputMeasurement {
+				continue
+			}
+			fields, err := p.Fields()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read aggregate point fields: %w", err)
+			}
+			if v, ok := fields[inputField]; ok {
+				if f, err := toFloat(v); err == nil {
+					values[p.Time().UnixNano()] = f
+				}
+			}
+		}
+		if len(values) == 0 {
+			if err := args.emptyResult(fmt.Sprintf("no %s aggregates to roll up", inputField)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// sliding input windows, stored every run, overlap. Walking back from the
+		// newest, skip any input whose window overlaps the last one kept:
+		var (
+			latest, keptEnd time.Time
+			in              []float64
+		)
+		for _, ns := range slices.Backward(slices.Sorted(maps.Keys(values))) {
+			v := values[ns]
+			end := args.windowEnd(time.Unix(0, ns), inDur)
+			if math.IsNaN(v) || (!keptEnd.IsZero() && end.After(keptEnd.Add(-inDur))) {
+				continue
+			}
+			keptEnd = end
+			if latest.IsZero() {
+				latest = end
+			}
+			if args.inInterval(latest, end.Add(-inDur/2), dur) {
+				in = append(in, v)
+			}
+		}
+		if len(in) == 0 || !args.enoughSamples("rollup "+inputField, r.Interval, len(in)) {
+			continue
+		}
+		args.Summary.AddSamplesRead(len(in))
+
+		point, err := args.newPoint(
+			args.intervalMeasurement(r.Interval),
+			args.WriteTags,
+			map[string]interface{}{rollupResultFieldName(args, r): numericStat(r.Stat, in)},
+			args.pointTime(args.intervalEnd(latest, dur), dur),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+		if point != nil {
+			retv = append(retv, point)
+		}
+		args.Summary.RecordIntervals("rollup", []string{r.Interval})
+	}
+
+	return retv, nil
+}
@@ -0,0 +1,55 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+func TestRollupCountsOverlappingInputsOnce(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// sliding 1h means stored every 10 minutes for 3h: 18 values, of which the
+	// hourly ones at 12:00, 11:00, and 10:00 (valued 2) don't overlap.
+	row := models.Row{Name: "wx_agg", Columns: []string{"time", "temp_mean_1h"}}
+	for i := 17; i >= 0; i-- {
+		ts := now.Add(-time.Duration(i) * 10 * time.Minute)
+		v := 100.0
+		if ts.Minute() == 0 {
+			v = 2
+		}
+		row.Values = append(row.Values, []any{ts.Format(time.RFC3339), json.Number(fmt.Sprint(v))})
+	}
+	client := &scriptedClient{rows: map[string]models.Row{"SELECT time, temp_mean_1h FROM": row}}
+
+	r, err := ParseRollup("temp_mean_1h:mean:3h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, err := RollupAgg(context.Background(), RollupAggArgs{
+		CommonArgs: CommonArgs{
+			MeasurementTo:     "wx_agg",
+			TimestampStrategy: TimestampTrailing,
+			Now:               func() time.Time { return now },
+		},
+		Store:   Store{Influx: client},
+		Rollups: []Rollup{r},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 {
+		t.Fatalf("got %d points, want 1", len(points))
+	}
+	fields, err := points[0].Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fields["temp_mean_1h_mean_3h"]; got != 2.0 {
+		t.Errorf("temp_mean_1h_mean_3h = %v, want 2 from the three non-overlapping hours", got)
+	}
+}
@@ -0,0 +1,63 @@
+package aggregate
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Rounding maps output field name prefixes to the number of decimal places to which
+// matching float fields are rounded. The longest matching prefix wins; the empty
+// prefix, if present, matches every field. Fields matching no prefix aren't rounded.
+type Rounding map[string]int
+
+// places returns the number of decimal places for the named field, and whether it
+// should be rounded at all. A prefix matches the field itself and any field named
+// <prefix>_..., so "wind_dir" matches "wind_dir_mean_1h" but not "wind_direction".
+func (r Rounding) places(field string) (int, bool) {
+	best := -1
+	retv := 0
+	for prefix, n := range r {
+		if prefix != "" && field != prefix && !strings.HasPrefix(field, prefix+"_") {
+			continue
+		}
+		if len(prefix) > best {
+			best, retv = len(prefix), n
+		}
+	}
+	return retv, best >= 0
+}
+
+// RoundPoints returns the given points with their float fields rounded per r.
+// Integer, string, and boolean fields are unchanged.
+func RoundPoints(points []*influxdb.Point, r Rounding) ([]*influxdb.Point, error) {
+	if len(r) == 0 {
+		return points, nil
+	}
+	retv := make([]*influxdb.Point, len(points))
+	for i, p := range points {
+		pFields, err := p.Fields()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read point fields: %w", err)
+		}
+		fields := maps.Clone(pFields) // p caches its fields, so they must not be modified
+		for name, v := range fields {
+			f, ok := v.(float64)
+			if !ok {
+				continue
+			}
+			if n, ok := r.places(name); ok {
+				// formatting and re-parsing yields the float nearest the rounded decimal:
+				fields[name], _ = strconv.ParseFloat(strconv.FormatFloat(f, 'f', n, 64), 64)
+			}
+		}
+		retv[i], err = influxdb.NewPoint(p.Name(), p.Tags(), fields, p.Time())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+	}
+	return retv, nil
+}
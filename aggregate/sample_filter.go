@@ -0,0 +1,106 @@
+package aggregate
+
+import (
+	"math"
+	"sort"
+)
+
+// SampleFilter drops invalid and outlier source samples before they are aggregated.
+type SampleFilter struct {
+	// Sentinels are values which stations write to indicate a failed reading
+	// (e.g. -9999); matching values are treated as missing.
+	Sentinels []float64
+
+	// MAD, if > 0, drops values more than MAD median absolute deviations from
+	// the median of the field's values in the queried window.
+	MAD float64
+
+	// Ranges holds per-field hard limits; values outside [Min, Max] are dropped.
+	Ranges map[string]ValueRange
+}
+
+type ValueRange struct {
+	Min float64
+	Max float64
+}
+
+// apply marks values of the field at index idx as missing (NaN) when they are
+// sentinels or outliers, returning the number of values dropped.
+func (f *SampleFilter) apply(field string, samples []sample, idx int, useMAD bool) int {
+	dropped := 0
+
+	if len(f.Sentinels) > 0 {
+		for _, s := range samples {
+			v := s.values[idx]
+			if !math.IsNaN(v) && f.isSentinel(v) {
+				s.values[idx] = math.NaN()
+				dropped++
+			}
+		}
+	}
+
+	if r, ok := f.Ranges[field]; ok {
+		for _, s := range samples {
+			v := s.values[idx]
+			if !math.IsNaN(v) && (v < r.Min || v > r.Max) {
+				s.values[idx] = math.NaN()
+				dropped++
+			}
+		}
+	}
+
+	if !useMAD || f.MAD <= 0 {
+		return dropped
+	}
+
+	var values []float64
+	for _, s := range samples {
+		if !math.IsNaN(s.values[idx]) {
+			values = append(values, s.values[idx])
+		}
+	}
+	if len(values) < 3 {
+		return dropped
+	}
+	med := median(values)
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - med)
+	}
+	mad := median(deviations)
+	if mad == 0 {
+		// more than half the values are identical; there's no meaningful spread to
+		// judge outliers against, and dropping every other value would be worse.
+		return dropped
+	}
+
+	for _, s := range samples {
+		v := s.values[idx]
+		if !math.IsNaN(v) && math.Abs(v-med)/mad > f.MAD {
+			s.values[idx] = math.NaN()
+			dropped++
+		}
+	}
+	return dropped
+}
+
+func (f *SampleFilter) isSentinel(v float64) bool {
+	for _, sentinel := range f.Sentinels {
+		// sentinels are typically stored as float32 or with a single decimal place,
+		// so allow for a tiny representation error:
+		if math.Abs(v-sentinel) <= 1e-6*math.Max(1, math.Abs(sentinel)) {
+			return true
+		}
+	}
+	return false
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,47 @@
+package aggregate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSampleFilterIsSentinel(t *testing.T) {
+	f := &SampleFilter{Sentinels: []float64{-9999, 6553.5}}
+	tests := []struct {
+		v    float64
+		want bool
+	}{
+		{-9999, true},
+		{6553.5, true},
+		{float64(float32(6553.5)), true},
+		{6553.500001, true}, // within representation error
+		{6553.6, false},
+		{-9998, false},
+		{0, false},
+		{255, false},
+	}
+	for _, tt := range tests {
+		if got := f.isSentinel(tt.v); got != tt.want {
+			t.Errorf("isSentinel(%v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestSampleFilterApplySentinels(t *testing.T) {
+	f := &SampleFilter{Sentinels: []float64{-9999}}
+	samples := []sample{
+		{values: []float64{1}},
+		{values: []float64{-9999}},
+		{values: []float64{math.NaN()}},
+		{values: []float64{3}},
+	}
+	if dropped := f.apply("temp", samples, 0, false); dropped != 1 {
+		t.Errorf("dropped %d values, want 1", dropped)
+	}
+	if !math.IsNaN(samples[1].values[0]) {
+		t.Errorf("sentinel value not dropped: %v", samples[1].values[0])
+	}
+	if samples[0].values[0] != 1 || samples[3].values[0] != 3 {
+		t.Errorf("valid values changed: %v, %v", samples[0].values[0], samples[3].values[0])
+	}
+}
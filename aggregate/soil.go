@@ -0,0 +1,119 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type SoilAggArgs struct {
+	CommonArgs
+	Store
+
+	Fields []string // soil moisture and/or temperature fields, one per probe
+}
+
+const (
+	soilInterval24h = "24h"
+	soilInterval1h  = "1h"
+)
+
+func allSoilIntervals() []string {
+	return []string{soilInterval24h, soilInterval1h}
+}
+
+func soilIntervalToDuration(interval string) time.Duration {
+	switch interval {
+	case soilInterval24h:
+		return 24 * time.Hour
+	case soilInterval1h:
+		return time.Hour
+	default:
+		panic(fmt.Sprintf("unknown soil interval: %s", interval))
+	}
+}
+
+func soilResultFieldName(args SoilAggArgs, field, stat, interval string) string {
+	return args.intervalFieldName(field+"_"+stat, interval)
+}
+
+func SoilAgg(ctx context.Context, args SoilAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	// read every probe's field in one query over the longest interval;
+	// shorter intervals will filter from this data.
+	samples, err := querySamples(ctx, args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       args.Fields,
+		SourceFields: args.SourceFields,
+		Window:       soilInterval24h,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		Summary:      args.Summary,
+	}, intervalDurations(allSoilIntervals(), soilIntervalToDuration)...))
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, args.emptyResult("no soil data to aggregate")
+	}
+	args.Summary.AddSamplesRead(len(samples))
+	warnPassthroughIntervals("soil", samples, allSoilIntervals()...)
+
+	latestTime := samples[len(samples)-1].t
+	var retv []*influxdb.Point
+
+	for _, interval := range allSoilIntervals() {
+		dur := soilIntervalToDuration(interval)
+		fields := make(map[string]interface{})
+
+		for i, field := range args.Fields {
+			minV := math.Inf(1)
+			maxV := math.Inf(-1)
+			sum := 0.0
+			n := 0
+			for _, s := range samples {
+				if !args.inInterval(latestTime, s.t, dur) || math.IsNaN(s.values[i]) {
+					continue
+				}
+				minV = math.Min(minV, s.values[i])
+				maxV = math.Max(maxV, s.values[i])
+				sum += s.values[i]
+				n++
+			}
+			if n == 0 || !args.enoughSamples(field, interval, n) {
+				continue
+			}
+			fields[soilResultFieldName(args, field, "min", interval)] = minV
+			fields[soilResultFieldName(args, field, "max", interval)] = maxV
+			fields[soilResultFieldName(args, field, "mean", interval)] = sum / float64(n)
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		point, err := args.newPoint(
+			args.intervalMeasurement(interval),
+			args.WriteTags,
+			fields,
+			args.pointTime(args.intervalEnd(latestTime, dur), dur),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+		if point != nil {
+			retv = append(retv, point)
+		}
+		args.Summary.RecordIntervals("soil", []string{interval})
+	}
+
+	return retv, nil
+}
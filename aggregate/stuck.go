@@ -0,0 +1,105 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type StuckAggArgs struct {
+	CommonArgs
+	Store
+
+	Fields     []string // fields to check for a stuck sensor
+	MinSamples int      // minimum number of samples for an interval to be judged; defaults to DefaultStuckMinSamples
+}
+
+const (
+	stuckInterval          = "1h"
+	stuckIntervalDuration  = time.Hour
+	DefaultStuckMinSamples = 10
+)
+
+func stuckResultFieldName(args StuckAggArgs, field string) string {
+	return args.intervalFieldName(field+"_stuck", stuckInterval)
+}
+
+// StuckAgg flags fields whose every sample over the past hour is exactly identical.
+// Real sensors jitter in their last digit, so a long run of exactly-equal readings
+// is far more likely a frozen sensor than perfectly steady weather.
+func StuckAgg(ctx context.Context, args StuckAggArgs) ([]*influxdb.Point, error) {
+	minSamples := args.MinSamples
+	if minSamples <= 0 {
+		minSamples = DefaultStuckMinSamples
+	}
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	samples, err := querySamples(ctx, sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       args.Fields,
+		SourceFields: args.SourceFields,
+		Window:       stuckInterval,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		MADExempt:    args.Fields, // a stuck sensor has no spread to judge outliers against
+		Summary:      args.Summary,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, args.emptyResult("no data to check for stuck sensors")
+	}
+	args.Summary.AddSamplesRead(len(samples))
+
+	fields := make(map[string]interface{})
+	for i, field := range args.Fields {
+		n := 0
+		first := math.NaN()
+		stuck := true
+		for _, s := range samples {
+			v := s.values[i]
+			if math.IsNaN(v) {
+				continue
+			}
+			if n == 0 {
+				first = v
+			} else if v != first {
+				stuck = false
+			}
+			n++
+		}
+		if n < minSamples {
+			continue
+		}
+		if stuck {
+			log.Printf("WARNING: %s reported exactly %g in all %d samples over the past %s; the sensor may be stuck", field, first, n, stuckInterval)
+		}
+		fields[stuckResultFieldName(args, field)] = stuck
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	point, err := args.newPoint(
+		args.intervalMeasurement(stuckInterval),
+		args.WriteTags,
+		fields,
+		args.pointTime(samples[len(samples)-1].t, stuckIntervalDuration),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+	}
+	args.Summary.RecordIntervals("stuck", []string{stuckInterval})
+	if point == nil {
+		return nil, nil
+	}
+	return []*influxdb.Point{point}, nil
+}
@@ -0,0 +1,176 @@
+package aggregate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// RunSummary collects statistics about a single run, for reporting to the operator.
+// All methods are safe to call on a nil *RunSummary, in which case they do nothing.
+type RunSummary struct {
+	Start          time.Time
+	Intervals      map[string][]string // aggregation name -> recomputed intervals
+	Fresh          map[string][]string // aggregation name -> intervals skipped as still fresh
+	SamplesRead    int
+	SamplesDropped int
+	PointsWritten  int
+	Errors         int
+
+	// QueryTime, ComputeTime, and WriteTime break the run's duration down into time
+	// spent waiting on InfluxDB queries, computing in this program, and waiting on
+	// InfluxDB writes.
+	QueryTime   time.Duration
+	ComputeTime time.Duration
+	WriteTime   time.Duration
+}
+
+func NewRunSummary(start time.Time) *RunSummary {
+	return &RunSummary{
+		Start:     start,
+		Intervals: make(map[string][]string),
+		Fresh:     make(map[string][]string),
+	}
+}
+
+// RecordIntervals records that the named aggregation recomputed the given intervals.
+func (s *RunSummary) RecordIntervals(aggName string, intervals []string) {
+	if s == nil {
+		return
+	}
+	s.Intervals[aggName] = append(s.Intervals[aggName], intervals...)
+}
+
+// RecordFreshIntervals records that the named aggregation skipped the given
+// intervals, since their stored aggregates were still fresh.
+func (s *RunSummary) RecordFreshIntervals(aggName string, intervals []string) {
+	if s == nil || len(intervals) == 0 {
+		return
+	}
+	s.Fresh[aggName] = append(s.Fresh[aggName], intervals...)
+}
+
+// AddSamplesRead records that n source samples were read.
+func (s *RunSummary) AddSamplesRead(n int) {
+	if s == nil {
+		return
+	}
+	s.SamplesRead += n
+}
+
+// AddSamplesDropped records that n source samples were dropped as sentinels or outliers.
+func (s *RunSummary) AddSamplesDropped(n int) {
+	if s == nil {
+		return
+	}
+	s.SamplesDropped += n
+}
+
+// AddQueryTime records that d was spent waiting on an InfluxDB query.
+func (s *RunSummary) AddQueryTime(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.QueryTime += d
+}
+
+// AddWriteTime records that d was spent waiting on an InfluxDB write.
+func (s *RunSummary) AddWriteTime(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.WriteTime += d
+}
+
+// AddComputeTime records that d was spent computing, excluding queries and writes.
+func (s *RunSummary) AddComputeTime(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.ComputeTime += d
+}
+
+// AddError records that the run encountered an error.
+func (s *RunSummary) AddError() {
+	if s == nil {
+		return
+	}
+	s.Errors++
+}
+
+// IntervalsComputed returns the total number of intervals recomputed, across all aggregations.
+func (s *RunSummary) IntervalsComputed() int {
+	return countIntervals(s.Intervals)
+}
+
+// IntervalsFresh returns the total number of intervals skipped as still fresh,
+// across all aggregations.
+func (s *RunSummary) IntervalsFresh() int {
+	return countIntervals(s.Fresh)
+}
+
+func countIntervals(m map[string][]string) int {
+	n := 0
+	for _, intervals := range m {
+		n += len(intervals)
+	}
+	return n
+}
+
+// MetadataPoint returns a point recording this run's statistics, timestamped now,
+// for operators to graph the aggregator's health over time.
+func (s *RunSummary) MetadataPoint(measurement string, tags map[string]string) (*influxdb.Point, error) {
+	now := time.Now()
+	return influxdb.NewPoint(
+		measurement,
+		tags,
+		map[string]any{
+			"duration_s":         now.Sub(s.Start).Seconds(),
+			"intervals_computed": int64(s.IntervalsComputed()),
+			"intervals_fresh":    int64(s.IntervalsFresh()),
+			"samples_read":       int64(s.SamplesRead),
+			"samples_dropped":    int64(s.SamplesDropped),
+			"points_written":     int64(s.PointsWritten),
+			"errors":             int64(s.Errors),
+			"query_s":            s.QueryTime.Seconds(),
+			"compute_s":          s.ComputeTime.Seconds(),
+			"write_s":            s.WriteTime.Seconds(),
+		},
+		now,
+	)
+}
+
+// String returns a concise, single-line summary of the run.
+func (s *RunSummary) String() string {
+	return fmt.Sprintf("recomputed: %s; still fresh: %s; samples read: %d; samples dropped: %d; points written: %d; duration: %s (%s)",
+		formatIntervals(s.Intervals), formatIntervals(s.Fresh), s.SamplesRead, s.SamplesDropped, s.PointsWritten,
+		time.Since(s.Start).Round(time.Millisecond), s.Timings())
+}
+
+// formatIntervals formats a map of aggregation names to intervals, e.g.
+// "rain[24h,1h] wind[5m]", or "none" if it's empty.
+func formatIntervals(m map[string][]string) string {
+	aggNames := make([]string, 0, len(m))
+	for k := range m {
+		aggNames = append(aggNames, k)
+	}
+	sort.Strings(aggNames)
+
+	intervalParts := make([]string, 0, len(aggNames))
+	for _, k := range aggNames {
+		intervalParts = append(intervalParts, fmt.Sprintf("%s[%s]", k, strings.Join(m[k], ",")))
+	}
+	if len(intervalParts) == 0 {
+		return "none"
+	}
+	return strings.Join(intervalParts, " ")
+}
+
+// Timings returns the run's query, compute, and write times, formatted for logging.
+func (s *RunSummary) Timings() string {
+	return fmt.Sprintf("query %s, compute %s, write %s",
+		s.QueryTime.Round(time.Millisecond), s.ComputeTime.Round(time.Millisecond), s.WriteTime.Round(time.Millisecond))
+}
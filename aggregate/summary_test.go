@@ -0,0 +1,31 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetadataPointTimings(t *testing.T) {
+	s := NewRunSummary(time.Now().Add(-4 * time.Second))
+	s.AddQueryTime(1500 * time.Millisecond)
+	s.AddQueryTime(500 * time.Millisecond)
+	s.AddComputeTime(250 * time.Millisecond)
+	s.AddWriteTime(time.Second)
+
+	p, err := s.MetadataPoint("wx_agg_runs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := p.Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]float64{"query_s": 2, "compute_s": 0.25, "write_s": 1} {
+		if got := fields[name]; got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	if got := s.Timings(); got != "query 2s, compute 250ms, write 1s" {
+		t.Errorf("Timings() = %q", got)
+	}
+}
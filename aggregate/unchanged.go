@@ -0,0 +1,103 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// unchangedLookback bounds how far back to look for the most recently stored value
+// of each field. It covers the longest aggregate interval with room to spare.
+const unchangedLookback = "48h"
+
+// DropUnchangedPoints returns the given points except those whose every field equals
+// the most recently stored value of that field in the point's measurement. Floats
+// are compared within tolerance; all other values must be exactly equal.
+func DropUnchangedPoints(ctx context.Context, store Store, queryTags map[string]string, points []*influxdb.Point, tolerance float64) ([]*influxdb.Point, error) {
+	tagsWhere := PartialWhereClauseForTags(queryTags)
+
+	// fetch the latest stored values for every field, per measurement, in one query each:
+	fieldsByMeasurement := make(map[string][]string)
+	for _, p := range points {
+		pFields, err := p.Fields()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read point fields: %w", err)
+		}
+		for name := range pFields {
+			fieldsByMeasurement[p.Name()] = append(fieldsByMeasurement[p.Name()], name)
+		}
+	}
+	stored := make(map[string]map[string]any)
+	for measurement, fields := range fieldsByMeasurement {
+		sort.Strings(fields)
+		fields = slices.Compact(fields)
+		selects := make([]string, len(fields))
+		for i, f := range fields {
+			selects[i] = fmt.Sprintf(`last("%s") AS "%s"`, f, f)
+		}
+		q := fmt.Sprintf("SELECT %s FROM %s WHERE time >= now()-%s %s",
+			strings.Join(selects, ", "), measurement, unchangedLookback, tagsWhere)
+		r, err := runQuery(ctx, store, q)
+		if err != nil {
+			return nil, err
+		}
+		series, err := singleSeries(r)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]any)
+		stored[measurement] = values
+		if series == nil || len(series.Values) == 0 {
+			continue
+		}
+		for _, f := range fields {
+			if idx, err := columnIndex(series, f); err == nil {
+				values[f] = series.Values[0][idx]
+			}
+		}
+	}
+
+	var retv []*influxdb.Point
+	for _, p := range points {
+		pFields, _ := p.Fields()
+		unchanged := true
+		for name, v := range pFields {
+			prev, ok := stored[p.Name()][name]
+			if !ok || !sameValue(v, prev, tolerance) {
+				unchanged = false
+				break
+			}
+		}
+		if !unchanged {
+			retv = append(retv, p)
+		}
+	}
+	if skipped := len(points) - len(retv); skipped > 0 {
+		log.Printf("skipping %d unchanged aggregate points", skipped)
+	}
+	return retv, nil
+}
+
+// sameValue reports whether a newly computed field value equals a stored value as
+// returned in a query result.
+func sameValue(v, stored any, tolerance float64) bool {
+	if stored == nil {
+		return false
+	}
+	switch v := v.(type) {
+	case float64:
+		s, err := toFloat(stored)
+		return err == nil && math.Abs(v-s) <= tolerance
+	case int64:
+		s, err := toFloat(stored)
+		return err == nil && float64(v) == s
+	default:
+		return fmt.Sprint(v) == fmt.Sprint(stored)
+	}
+}
@@ -0,0 +1,142 @@
+package aggregate
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/cdzombak/libwx"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Unit is a unit in which an output field is recorded.
+type Unit string
+
+const (
+	UnitC      Unit = "C"  // temperature
+	UnitF      Unit = "F"  // temperature
+	UnitDeltaC Unit = "ΔC" // temperature difference, e.g. a spread or standard deviation
+	UnitDeltaF Unit = "ΔF" // temperature difference
+	UnitMm     Unit = "mm"
+	UnitIn     Unit = "in"
+	UnitMmH    Unit = "mm/h"
+	UnitInH    Unit = "in/h"
+	UnitKm     Unit = "km"
+	UnitMi     Unit = "mi"
+	UnitNmi    Unit = "nmi"
+	UnitMph    Unit = Unit(WindSpeedMph)
+	UnitKmH    Unit = Unit(WindSpeedKmH)
+	UnitKnots  Unit = Unit(WindSpeedKnots)
+	UnitMps    Unit = Unit(WindSpeedMps)
+	UnitMb     Unit = "mb"
+	UnitInHg   Unit = "inHg"
+)
+
+// FieldUnits maps output field base names (names without an interval or field
+// suffix, e.g. "wind_u" or a raw source field like "temp") to the unit in which
+// those fields are recorded.
+type FieldUnits map[string]Unit
+
+// unitVariant is one unit system's variant of a field: its name suffix, and a
+// conversion from the field's recorded unit.
+type unitVariant struct {
+	suffix  string
+	convert func(v float64) float64
+}
+
+func identity(v float64) float64 { return v }
+
+// variants returns the metric and imperial variants of a field recorded in u.
+func (u Unit) variants() (metric, imperial unitVariant, err error) {
+	switch u {
+	case UnitC:
+		return unitVariant{"_c", identity}, unitVariant{"_f", func(v float64) float64 { return libwx.TempC(v).F().Unwrap() }}, nil
+	case UnitF:
+		return unitVariant{"_c", func(v float64) float64 { return libwx.TempF(v).C().Unwrap() }}, unitVariant{"_f", identity}, nil
+	case UnitDeltaC:
+		return unitVariant{"_c", identity}, unitVariant{"_f", func(v float64) float64 { return v * 9 / 5 }}, nil
+	case UnitDeltaF:
+		return unitVariant{"_c", func(v float64) float64 { return v * 5 / 9 }}, unitVariant{"_f", identity}, nil
+	case UnitMm:
+		return unitVariant{"_mm", identity}, unitVariant{"_in", func(v float64) float64 { return v / 25.4 }}, nil
+	case UnitIn:
+		return unitVariant{"_mm", func(v float64) float64 { return v * 25.4 }}, unitVariant{"_in", identity}, nil
+	case UnitMmH:
+		return unitVariant{"_mmh", identity}, unitVariant{"_inh", func(v float64) float64 { return v / 25.4 }}, nil
+	case UnitInH:
+		return unitVariant{"_mmh", func(v float64) float64 { return v * 25.4 }}, unitVariant{"_inh", identity}, nil
+	case UnitKm:
+		return unitVariant{"_km", identity}, unitVariant{"_mi", func(v float64) float64 { return libwx.Km(v).Miles().Unwrap() }}, nil
+	case UnitMi:
+		return unitVariant{"_km", func(v float64) float64 { return libwx.Mile(v).Km().Unwrap() }}, unitVariant{"_mi", identity}, nil
+	case UnitNmi:
+		return unitVariant{"_km", func(v float64) float64 { return libwx.NauticalMile(v).Km().Unwrap() }},
+			unitVariant{"_mi", func(v float64) float64 { return libwx.NauticalMile(v).Miles().Unwrap() }}, nil
+	case UnitMph, UnitKmH, UnitKnots, UnitMps:
+		speedUnit := WindSpeedUnit(u)
+		return unitVariant{"_kmh", func(v float64) float64 { return speedUnit.Mph(v).KmH().Unwrap() }},
+			unitVariant{"_mph", func(v float64) float64 { return speedUnit.Mph(v).Unwrap() }}, nil
+	case UnitMb:
+		return unitVariant{"_hpa", identity}, unitVariant{"_inhg", func(v float64) float64 { return libwx.PressureMb(v).InHg().Unwrap() }}, nil
+	case UnitInHg:
+		return unitVariant{"_hpa", func(v float64) float64 { return libwx.PressureInHg(v).Mb().Unwrap() }}, unitVariant{"_inhg", identity}, nil
+	default:
+		return unitVariant{}, unitVariant{}, fmt.Errorf("unknown unit '%s'", u)
+	}
+}
+
+// unit returns the unit of the named output field, if it has one. The field name
+// may include an interval (e.g. "wind_u_1h") and must include fieldSuffix, if any.
+func (fu FieldUnits) unit(name, fieldSuffix string) (Unit, bool) {
+	name = strings.TrimSuffix(name, fieldSuffix)
+	if u, ok := fu[name]; ok {
+		return u, true
+	}
+	base, interval := splitIntervalFieldName(name)
+	if interval == 0 {
+		return "", false
+	}
+	u, ok := fu[base]
+	return u, ok
+}
+
+// DualUnitPoints returns the given points with each float field that has a unit in
+// units replaced by a metric and an imperial variant, named with a unit suffix
+// before any field suffix: e.g. "dewpoint_spread_1h" becomes "dewpoint_spread_1h_c"
+// and "dewpoint_spread_1h_f". Other fields are unchanged.
+func DualUnitPoints(points []*influxdb.Point, units FieldUnits, fieldSuffix string) ([]*influxdb.Point, error) {
+	if len(units) == 0 {
+		return points, nil
+	}
+	retv := make([]*influxdb.Point, len(points))
+	for i, p := range points {
+		pFields, err := p.Fields()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read point fields: %w", err)
+		}
+		fields := maps.Clone(pFields) // p caches its fields, so they must not be modified
+		for name, v := range pFields {
+			f, ok := v.(float64)
+			if !ok {
+				continue
+			}
+			u, ok := units.unit(name, fieldSuffix)
+			if !ok {
+				continue
+			}
+			metric, imperial, err := u.variants()
+			if err != nil {
+				return nil, fmt.Errorf("field '%s': %w", name, err)
+			}
+			delete(fields, name)
+			stem := strings.TrimSuffix(name, fieldSuffix)
+			fields[stem+metric.suffix+fieldSuffix] = metric.convert(f)
+			fields[stem+imperial.suffix+fieldSuffix] = imperial.convert(f)
+		}
+		retv[i], err = influxdb.NewPoint(p.Name(), p.Tags(), fields, p.Time())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+	}
+	return retv, nil
+}
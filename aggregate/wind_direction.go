@@ -0,0 +1,845 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cdzombak/libwx"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type WindDirectionAggArgs struct {
+	CommonArgs
+	Store
+
+	WindDirectionField string
+	// WindDirectionFormat is how WindDirectionField is recorded: WindDirDegrees
+	// (the default) or WindDirCompass (a 4-, 8-, or 16-point compass string, e.g.
+	// "NE" or "NNE").
+	WindDirectionFormat string
+	WindSpeedField      string
+	WindSpeedUnit       WindSpeedUnit               // unit of WindSpeedField; defaults to mph
+	CompassPrecision    libwx.DirectionStrPrecision // for the intercardinal field; defaults to DirectionStrPrecision2 (8-point)
+
+	// DirectionRange is the range in which WindDirectionField's values are recorded:
+	// DirRangeAuto (the default; any value, wrapped), DirRangeUnsigned ([0, 360]),
+	// or DirRangeSigned ([-180, 180]). Values outside it are dropped.
+	DirectionRange string
+
+	// DirectionOffset is added to every direction, after normalizing it per
+	// DirectionRange, to correct an anemometer mounted with its 0 away from true north.
+	DirectionOffset float64
+
+	// OmitStdDev suppresses the stddev fields. The standard deviation is still
+	// computed, since it decides whether the intercardinal field is VAR.
+	OmitStdDev bool
+
+	// WeightBy selects the speed which weights direction statistics: WindWeightSustained
+	// (WindSpeedField, the default) or WindWeightGust (WindGustField, in WindSpeedUnit).
+	WeightBy      string
+	WindGustField string
+
+	// IncludeUnpaired includes samples which have a direction but lack the speed
+	// which weights it (WindSpeedField, or WindGustField when weighting by gust).
+	// Each is weighted by the mean weight of its interval's paired samples. By
+	// default, such samples are excluded.
+	IncludeUnpaired bool
+
+	// WeightDecay, if positive, also weights each sample by exp(-age/WeightDecay),
+	// where age is measured from the interval's newest sample, so that recent samples
+	// count for more than older ones.
+	WeightDecay time.Duration
+
+	// ModeBy, if set, also writes the modal direction: the center of the compass
+	// sector (at CompassPrecision) in which the most samples fell (WindDirModeSamples)
+	// or the most time was spent (WindDirModeTime). Calm samples are excluded.
+	ModeBy string
+
+	// VarHysteresis, if positive, is a dead band in degrees around each interval's VAR
+	// threshold: once an interval's stored intercardinal field is VAR, its stddev must
+	// fall below the threshold minus VarHysteresis to be labeled with a direction, and
+	// once labeled, must reach the threshold plus VarHysteresis to become VAR.
+	VarHysteresis float64
+
+	// RecomputeOn selects when an interval's stored aggregate is recomputed:
+	// RecomputeOnAge (once it's older than the interval allows; the default) or
+	// RecomputeOnNewData (whenever source data newer than the samples it summarizes
+	// has arrived). RecomputeOnNewData requires sliding windows.
+	RecomputeOn string
+}
+
+const (
+	RecomputeOnAge     = "age"
+	RecomputeOnNewData = "new-data"
+)
+
+const (
+	WindWeightSustained = "sustained"
+	WindWeightGust      = "gust"
+)
+
+const (
+	WindDirModeSamples = "samples"
+	WindDirModeTime    = "time"
+)
+
+const (
+	WindDirDegrees = "degrees"
+	WindDirCompass = "compass"
+)
+
+const (
+	DirRangeAuto     = "auto"
+	DirRangeUnsigned = "unsigned"
+	DirRangeSigned   = "signed"
+)
+
+// NormalizeDirection interprets a wind direction recorded in the given range
+// (DirRangeAuto, DirRangeUnsigned, or DirRangeSigned) and returns it in [0, 360),
+// or false if it's outside that range. Both ends of each range are accepted, since
+// 360 and -180 are common spellings of north and south: in the signed range, -90
+// is 270 and ±180 is 180; in either, 360 is 0. NaN and infinite values are never
+// a direction.
+func NormalizeDirection(v float64, dirRange string) (libwx.Degree, bool) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	switch dirRange {
+	case DirRangeUnsigned:
+		if v < 0 || v > 360 {
+			return 0, false
+		}
+	case DirRangeSigned:
+		if v < -180 || v > 180 {
+			return 0, false
+		}
+	}
+	v = math.Mod(v, 360)
+	if v < 0 {
+		v += 360
+	}
+	return libwx.Degree(v), true
+}
+
+// RotateDirection adds offset degrees (which may be negative) to a direction in
+// [0, 360), returning the result wrapped to [0, 360); e.g. 355 + 10 is 5.
+func RotateDirection(dir libwx.Degree, offset float64) libwx.Degree {
+	v := math.Mod(dir.Unwrap()+offset, 360)
+	if v < 0 {
+		v += 360
+	}
+	if v >= 360 { // a tiny negative v rounds up to 360 above
+		v = 0
+	}
+	return libwx.Degree(v + 0) // + 0 turns -0 into 0
+}
+
+// ParseDirectionStr is the inverse of libwx.DirectionStr: it parses a compass point
+// at any precision (e.g. "N", "NE", or "NNE"; case-insensitive) to the direction it
+// names, in degrees.
+func ParseDirectionStr(s string) (libwx.Degree, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for i := 0; i < 16; i++ {
+		deg := libwx.Degree(float64(i) * 22.5)
+		if libwx.DirectionStr(deg, libwx.DirectionStrPrecision3) == s {
+			return deg, true
+		}
+	}
+	return 0, false
+}
+
+// compassDirectionParser parses a compass string from a query result to degrees.
+// An unparseable value is treated as a missing sample.
+func compassDirectionParser(v any) (float64, error) {
+	str, ok := v.(string)
+	if !ok {
+		return math.NaN(), nil
+	}
+	deg, ok := ParseDirectionStr(str)
+	if !ok {
+		return math.NaN(), nil
+	}
+	return deg.Unwrap(), nil
+}
+
+const (
+	wdInterval6h  = "6h"
+	wdInterval3h  = "3h"
+	wdInterval1h  = "1h"
+	wdInterval30m = "30m"
+	wdInterval15m = "15m"
+	wdInterval5m  = "5m"
+)
+
+func allWindDirectionIntervals() []string {
+	return []string{
+		wdInterval6h,
+		wdInterval3h,
+		wdInterval1h,
+		wdInterval30m,
+		wdInterval15m,
+		wdInterval5m,
+	}
+}
+
+func windDirIntervalToDuration(interval string) time.Duration {
+	switch interval {
+	case wdInterval6h:
+		return 6 * time.Hour
+	case wdInterval3h:
+		return 3 * time.Hour
+	case wdInterval1h:
+		return time.Hour
+	case wdInterval30m:
+		return 30 * time.Minute
+	case wdInterval15m:
+		return 15 * time.Minute
+	case wdInterval5m:
+		return 5 * time.Minute
+	default:
+		panic(fmt.Sprintf("unknown interval: %s", interval))
+	}
+}
+
+func maxTimeBetweenAggsForWindDirInterval(interval string) time.Duration {
+	switch interval {
+	case wdInterval6h:
+		return 20 * time.Minute
+	case wdInterval3h:
+		return 10 * time.Minute
+	case wdInterval1h:
+		return 5 * time.Minute
+	case wdInterval30m:
+		return 2*time.Minute + 30*time.Second
+	case wdInterval15m:
+		return 2*time.Minute + 30*time.Second
+	case wdInterval5m:
+		return 1 * time.Minute
+	default:
+		panic(fmt.Sprintf("unknown interval: %s", interval))
+	}
+}
+
+func varThresholdForWindDirInterval(interval string) float64 {
+	switch interval {
+	case wdInterval6h:
+		return 60
+	case wdInterval3h:
+		return 55
+	case wdInterval1h:
+		return 52
+	case wdInterval30m:
+		return 51.5
+	case wdInterval15m:
+		return 51
+	default:
+		return 50.0
+	}
+}
+
+// varThreshold returns the stddev at and above which the given interval's
+// intercardinal field is VAR, shifted by VarHysteresis away from its stored label
+// in prevVar, if it has one, so the label changes only once stddev clearly crosses
+// the threshold.
+func (args WindDirectionAggArgs) varThreshold(interval string, prevVar map[string]bool) float64 {
+	threshold := varThresholdForWindDirInterval(interval)
+	wasVar, ok := prevVar[interval]
+	switch {
+	case !ok:
+		return threshold
+	case wasVar:
+		return threshold - args.VarHysteresis
+	default:
+		return threshold + args.VarHysteresis
+	}
+}
+
+// CompassPrecisionFromPoints maps a number of compass points (4, 8, or 16) to the
+// corresponding libwx.DirectionStrPrecision.
+func CompassPrecisionFromPoints(points int) (libwx.DirectionStrPrecision, error) {
+	switch points {
+	case 4:
+		return libwx.DirectionStrPrecision1, nil
+	case 8:
+		return libwx.DirectionStrPrecision2, nil
+	case 16:
+		return libwx.DirectionStrPrecision3, nil
+	default:
+		return 0, fmt.Errorf("compass precision must be 4, 8, or 16 (got %d)", points)
+	}
+}
+
+func wdMeanResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName(args.WindDirectionField+"_mean", interval)
+}
+
+func wdStdDevResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName(args.WindDirectionField+"_stddev", interval)
+}
+
+func wdMeanIntercardinalResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName(args.WindDirectionField+"_mean_intercardinal", interval)
+}
+
+func wdModeResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName(args.WindDirectionField+"_mode", interval)
+}
+
+func wdSamplesResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName(args.WindDirectionField+"_samples", interval)
+}
+
+func wdCoverageResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName(args.WindDirectionField+"_coverage", interval)
+}
+
+// wdLatestSampleResultFieldName is the field recording the time of the newest source
+// sample an aggregate summarizes, in Unix milliseconds, written with RecomputeOnNewData.
+func wdLatestSampleResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName(args.WindDirectionField+"_latest_sample", interval)
+}
+
+func wdUResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName("wind_u", interval)
+}
+
+func wdVResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName("wind_v", interval)
+}
+
+func wdResultantResultFieldName(args WindDirectionAggArgs, interval string) string {
+	return args.intervalFieldName("wind_resultant", interval)
+}
+
+// FieldUnits returns the units of this aggregation's output fields which have one.
+func (args WindDirectionAggArgs) FieldUnits() FieldUnits {
+	speedUnit := args.WindSpeedUnit
+	if speedUnit == "" {
+		speedUnit = WindSpeedMph
+	}
+	return FieldUnits{"wind_u": Unit(speedUnit), "wind_v": Unit(speedUnit), "wind_resultant": Unit(speedUnit)}
+}
+
+// WindSpeedUnit is the unit in which the source wind speed field is recorded.
+type WindSpeedUnit string
+
+const (
+	WindSpeedMph   WindSpeedUnit = "mph"
+	WindSpeedKmH   WindSpeedUnit = "kmh"
+	WindSpeedKnots WindSpeedUnit = "knots"
+	WindSpeedMps   WindSpeedUnit = "m/s"
+)
+
+// ParseWindSpeedUnit parses one of "mph", "kmh", "knots", or "m/s".
+func ParseWindSpeedUnit(s string) (WindSpeedUnit, error) {
+	switch u := WindSpeedUnit(s); u {
+	case WindSpeedMph, WindSpeedKmH, WindSpeedKnots, WindSpeedMps:
+		return u, nil
+	default:
+		return "", fmt.Errorf("wind speed unit must be one of mph, kmh, knots, m/s (got '%s')", s)
+	}
+}
+
+// Mph returns a speed recorded in this unit as a typed libwx speed.
+func (u WindSpeedUnit) Mph(v float64) libwx.SpeedMph {
+	switch u {
+	case WindSpeedKmH:
+		return libwx.SpeedKmH(v).Mph()
+	case WindSpeedKnots:
+		return libwx.SpeedKnots(v).Mph()
+	case WindSpeedMps:
+		return libwx.SpeedKmH(v * 3.6).Mph()
+	default:
+		return libwx.SpeedMph(v)
+	}
+}
+
+// FromMph converts a typed libwx speed back to this unit, for output.
+func (u WindSpeedUnit) FromMph(s libwx.SpeedMph) float64 {
+	switch u {
+	case WindSpeedKmH:
+		return s.KmH().Unwrap()
+	case WindSpeedKnots:
+		return s.Knots().Unwrap()
+	case WindSpeedMps:
+		return s.KmH().Unwrap() / 3.6
+	default:
+		return s.Unwrap()
+	}
+}
+
+type wdDataPoint struct {
+	t      time.Time
+	dir    libwx.Degree
+	spd    libwx.SpeedMph // 0 if the sample has no speed
+	weight libwx.SpeedMph // the speed weighting dir; spd unless weighting by gust
+
+	// unpaired is set for a sample lacking the speed which weights it; see
+	// WindDirectionAggArgs.IncludeUnpaired.
+	unpaired bool
+}
+
+// withUnpairedWeights returns an interval's data with each unpaired sample given
+// the mean weight of its paired samples, or if it has none, equal weights.
+func withUnpairedWeights(data []wdDataPoint) []wdDataPoint {
+	if !slices.ContainsFunc(data, func(dp wdDataPoint) bool { return dp.unpaired }) {
+		return data
+	}
+	var sum libwx.SpeedMph
+	n := 0
+	for _, dp := range data {
+		if !dp.unpaired {
+			sum += dp.weight
+			n++
+		}
+	}
+	weight := libwx.SpeedMph(1)
+	if n > 0 {
+		weight = sum / libwx.SpeedMph(n)
+	}
+	retv := slices.Clone(data)
+	for i := range retv {
+		if retv[i].unpaired {
+			retv[i].weight = weight
+		}
+	}
+	return retv
+}
+
+func dirSeriesFromWd(data []wdDataPoint) []libwx.Degree {
+	retv := make([]libwx.Degree, len(data))
+	for i, dp := range data {
+		retv[i] = dp.dir
+	}
+	return retv
+}
+
+func spdSeriesFromWd(data []wdDataPoint) []libwx.SpeedMph {
+	retv := make([]libwx.SpeedMph, len(data))
+	for i, dp := range data {
+		retv[i] = dp.spd
+	}
+	return retv
+}
+
+func weightSeriesFromWd(data []wdDataPoint) []libwx.SpeedMph {
+	retv := make([]libwx.SpeedMph, len(data))
+	for i, dp := range data {
+		retv[i] = dp.weight
+	}
+	return retv
+}
+
+// speedWeights unwraps speeds for use as weights in libwx's weighted statistics.
+func speedWeights(spds []libwx.SpeedMph) []float64 {
+	retv := make([]float64, len(spds))
+	for i, s := range spds {
+		retv[i] = s.Unwrap()
+	}
+	return retv
+}
+
+// windVectorMean returns the mean u (east-west) and v (north-south) wind components
+// of the given samples, using the meteorological convention: direction is where the
+// wind blows from, so a north wind (0°) has a negative v component.
+func windVectorMean(dirs []libwx.Degree, spds []libwx.SpeedMph) (u, v libwx.SpeedMph) {
+	if len(dirs) == 0 {
+		return 0, 0
+	}
+	for i, d := range dirs {
+		rad := d.Unwrap() * math.Pi / 180
+		u += libwx.SpeedMph(-spds[i].Unwrap() * math.Sin(rad))
+		v += libwx.SpeedMph(-spds[i].Unwrap() * math.Cos(rad))
+	}
+	return u / libwx.SpeedMph(len(dirs)), v / libwx.SpeedMph(len(dirs))
+}
+
+func filterWdSeries(data []wdDataPoint, f func(point wdDataPoint) bool) []wdDataPoint {
+	retv := []wdDataPoint{}
+	for _, dp := range data {
+		if f(dp) {
+			retv = append(retv, dp)
+		}
+	}
+	return retv
+}
+
+func WindDirectionAgg(ctx context.Context, args WindDirectionAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	compassPrecision := args.CompassPrecision
+	if compassPrecision == 0 {
+		compassPrecision = libwx.DirectionStrPrecision2
+	}
+
+	speedUnit := args.WindSpeedUnit
+	if speedUnit == "" {
+		speedUnit = WindSpeedMph
+	}
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	queryFields := []string{args.WindDirectionField, args.WindSpeedField}
+	weightByGust := args.WeightBy == WindWeightGust
+	if weightByGust {
+		queryFields = append(queryFields, args.WindGustField)
+	}
+	var parsers map[string]func(v any) (float64, error)
+	if args.WindDirectionFormat == WindDirCompass {
+		parsers = map[string]func(v any) (float64, error){args.WindDirectionField: compassDirectionParser}
+	}
+	newData := args.RecomputeOn == RecomputeOnNewData
+
+	// first, figure out which intervals we need to calculate.
+	var intervalsTodo []string
+	latestSummarized := make(map[string]int64) // interval -> newest sample its stored aggregate summarizes (Unix ms)
+	prevVar := make(map[string]bool)           // interval -> whether its stored intercardinal field is VAR, if it has one
+	for _, interval := range allWindDirectionIntervals() {
+		resultFieldName := wdMeanResultFieldName(args, interval)
+		if newData {
+			resultFieldName += ", " + wdLatestSampleResultFieldName(args, interval)
+		}
+		if args.VarHysteresis > 0 {
+			resultFieldName += ", " + wdMeanIntercardinalResultFieldName(args, interval)
+		}
+		dur := windDirIntervalToDuration(interval)
+		_, _, fixed := args.fixedWindow(args.now(), dur)
+		lookback := interval
+		if fixed {
+			// the last complete fixed window may have ended up to an interval ago:
+			lookback = fmt.Sprintf("%ds", int64(2*dur/time.Second))
+		}
+		q := fmt.Sprintf("SELECT time, %s FROM %s WHERE time >= %s-%s %s ORDER BY time DESC LIMIT 1", resultFieldName, args.intervalMeasurement(interval), timeLiteral(args.now()), lookback, tagsWhere)
+		r, err := runQuery(ctx, args.Store, q)
+		if err != nil {
+			return nil, err
+		}
+		series, err := singleSeries(r)
+		if err != nil {
+			return nil, err
+		}
+		if series == nil {
+			intervalsTodo = append(intervalsTodo, interval)
+			continue
+		}
+		timeIdx, err := columnIndex(series, args.timeColumn())
+		if err != nil {
+			return nil, err
+		}
+
+		t, err := args.parseTimeValue(series.Values[0][timeIdx])
+		if err != nil {
+			return nil, &ParseError{What: "time", Err: err}
+		}
+		if cardIdx := slices.Index(series.Columns, wdMeanIntercardinalResultFieldName(args, interval)); cardIdx >= 0 && args.VarHysteresis > 0 {
+			if card, ok := series.Values[0][cardIdx].(string); ok && card != "NIL" {
+				prevVar[interval] = card == "VAR"
+			}
+		}
+		if newData {
+			latestIdx := slices.Index(series.Columns, wdLatestSampleResultFieldName(args, interval))
+			if latestIdx < 0 || series.Values[0][latestIdx] == nil {
+				// written before RecomputeOnNewData was enabled:
+				intervalsTodo = append(intervalsTodo, interval)
+				continue
+			}
+			latest, err := toFloat(series.Values[0][latestIdx])
+			if err != nil {
+				return nil, &ParseError{What: "latest sample time", Err: err}
+			}
+			latestSummarized[interval] = int64(latest)
+		} else if fixed {
+			// a complete fixed window's aggregate doesn't change, so only a new window needs one:
+			if args.windowEnd(t, dur).Before(args.intervalEnd(args.now(), dur)) {
+				intervalsTodo = append(intervalsTodo, interval)
+			}
+		} else if args.now().Sub(args.windowEnd(t, dur)) > maxTimeBetweenAggsForWindDirInterval(interval) {
+			intervalsTodo = append(intervalsTodo, interval)
+		}
+	}
+
+	if len(latestSummarized) > 0 {
+		var err error
+		intervalsTodo, err = wdIntervalsWithNewData(ctx, args, tagsWhere, queryFields, parsers, intervalsTodo, latestSummarized)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fresh := slices.DeleteFunc(allWindDirectionIntervals(), func(interval string) bool {
+		return slices.Contains(intervalsTodo, interval)
+	})
+	args.Summary.RecordFreshIntervals("wind", fresh)
+	if len(fresh) > 0 {
+		log.Printf("wind intervals still fresh: %s (%d of %d)", strings.Join(fresh, ", "), len(fresh), len(fresh)+len(intervalsTodo))
+	}
+	if len(intervalsTodo) == 0 {
+		log.Printf("no intervals to calculate")
+		return nil, nil
+	}
+
+	now := args.now()
+
+	// gather the data we'll need:
+	samples, err := querySamples(ctx, args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       queryFields,
+		SourceFields: args.SourceFields,
+		Window:       intervalsTodo[0],
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		Parsers:      parsers,
+		MADExempt:    []string{args.WindDirectionField}, // direction is circular
+		Summary:      args.Summary,
+	}, intervalDurations(intervalsTodo, windDirIntervalToDuration)...))
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, args.emptyResult("no wind data to aggregate")
+	}
+
+	args.Summary.RecordIntervals("wind", intervalsTodo)
+	args.Summary.AddSamplesRead(len(samples))
+	warnPassthroughIntervals("wind", samples, intervalsTodo...)
+
+	// aggregate data by interval:
+	// create aggregate & output data structures:
+	intervalData := make(map[string][]wdDataPoint)
+	for _, interval := range intervalsTodo {
+		intervalData[interval] = []wdDataPoint{}
+	}
+	outOfRange := 0
+	for _, s := range samples {
+		if math.IsNaN(s.values[0]) {
+			continue
+		}
+		weight := s.values[1]
+		if weightByGust {
+			weight = s.values[2]
+		}
+		if !args.IncludeUnpaired && (math.IsNaN(s.values[1]) || math.IsNaN(weight)) {
+			continue
+		}
+		dir, ok := NormalizeDirection(s.values[0], args.DirectionRange)
+		if !ok {
+			outOfRange++
+			continue
+		}
+		dir = RotateDirection(dir, args.DirectionOffset)
+		dp := wdDataPoint{t: s.t, dir: dir}
+		if !math.IsNaN(s.values[1]) {
+			dp.spd = speedUnit.Mph(s.values[1])
+		}
+		if math.IsNaN(weight) {
+			dp.unpaired = true // weighted per interval, below
+		} else {
+			dp.weight = speedUnit.Mph(weight)
+		}
+		for _, interval := range intervalsTodo {
+			if args.inInterval(now, s.t, windDirIntervalToDuration(interval)) {
+				intervalData[interval] = append(intervalData[interval], dp)
+			}
+		}
+	}
+
+	if outOfRange > 0 {
+		log.Printf("dropped %d wind direction samples outside the %s range", outOfRange, args.DirectionRange)
+		args.Summary.AddSamplesDropped(outOfRange)
+	}
+
+	var retv []*influxdb.Point
+	cadence := sampleCadence(samples)
+
+	for _, interval := range intervalsTodo {
+		if len(intervalData[interval]) == 0 || !args.enoughSamples("wind", interval, len(intervalData[interval])) {
+			continue
+		}
+		data := intervalData[interval]
+		coverage := intervalCoverage(data[0].t, data[len(data)-1].t, cadence, windDirIntervalToDuration(interval))
+		if coverage < 1 {
+			log.Printf("[DEBUG] wind %s aggregate covers only %.0f%% of its interval, since source data spans %s",
+				interval, coverage*100, time.Duration(coverage*float64(windDirIntervalToDuration(interval))).Round(time.Second))
+		}
+		fields := make(map[string]interface{})
+
+		// calm samples (or, when weighting by gust, gust-free samples) carry no weight:
+		dataSeries := filterWdSeries(withUnpairedWeights(intervalData[interval]), func(dp wdDataPoint) bool {
+			return dp.weight > 0.001
+		})
+		dirSeries := dirSeriesFromWd(dataSeries)
+		weights := speedWeights(weightSeriesFromWd(dataSeries))
+		if args.WeightDecay > 0 && len(dataSeries) > 0 {
+			// ages are relative to the newest sample, so its weight is unchanged and
+			// the weights can't all underflow to zero:
+			newest := dataSeries[len(dataSeries)-1].t
+			for i, dp := range dataSeries {
+				weights[i] *= math.Exp(-newest.Sub(dp.t).Seconds() / args.WeightDecay.Seconds())
+			}
+		}
+
+		if args.ModeBy != "" {
+			if mode, ok := wdModalDirection(data, args.ModeBy, cadence, compassPrecision); ok {
+				fields[wdModeResultFieldName(args, interval)] = mode.Unwrap()
+			}
+		}
+
+		// Influx rejects writes that change a field's type, so each field must always
+		// be written with the same Go type: float64 for measurements, int64 for counts.
+		fields[wdSamplesResultFieldName(args, interval)] = int64(len(intervalData[interval]))
+		fields[wdCoverageResultFieldName(args, interval)] = coverage
+		if newData {
+			fields[wdLatestSampleResultFieldName(args, interval)] = samples[len(samples)-1].t.UnixMilli()
+		}
+
+		// the wind vector is always that of the sustained wind:
+		moving := filterWdSeries(intervalData[interval], func(dp wdDataPoint) bool {
+			return dp.spd > 0.001
+		})
+		u, v := windVectorMean(dirSeriesFromWd(moving), spdSeriesFromWd(moving))
+		fields[wdUResultFieldName(args, interval)] = speedUnit.FromMph(u)
+		fields[wdVResultFieldName(args, interval)] = speedUnit.FromMph(v)
+		// the resultant is the length of the mean vector:
+		fields[wdResultantResultFieldName(args, interval)] = speedUnit.FromMph(libwx.SpeedMph(math.Hypot(u.Unwrap(), v.Unwrap())))
+
+		if len(dirSeries) == 0 {
+			fields[wdMeanResultFieldName(args, interval)] = 0.0
+			fields[wdMeanIntercardinalResultFieldName(args, interval)] = "NIL"
+		} else if len(dirSeries) == 1 {
+			fields[wdMeanResultFieldName(args, interval)] = dirSeries[0].Unwrap()
+			if !args.OmitStdDev {
+				fields[wdStdDevResultFieldName(args, interval)] = 0.0
+			}
+			fields[wdMeanIntercardinalResultFieldName(args, interval)] = libwx.DirectionStr(dirSeries[0], compassPrecision)
+		} else {
+			mean, err := libwx.WeightedAvgDirectionDeg(dirSeries, weights)
+			if err != nil {
+				return nil, fmt.Errorf("failed to calculate weighted average wind direction: %w", err)
+			}
+			if math.IsNaN(mean.Unwrap()) {
+				return nil, fmt.Errorf("mean wind direction is NaN")
+			}
+			mean = mean.Clamped()
+
+			stdDev, err := libwx.WeightedStdDevDirectionDeg(dirSeries, weights)
+			if err != nil {
+				return nil, fmt.Errorf("failed to calculate weighted stddev of wind direction: %w", err)
+			}
+			if math.IsNaN(stdDev.Unwrap()) {
+				return nil, fmt.Errorf("stddev of wind direction is NaN")
+			}
+
+			card := "VAR"
+			if stdDev.Unwrap() < args.varThreshold(interval, prevVar) {
+				card = libwx.DirectionStr(mean, compassPrecision)
+			}
+			fields[wdMeanResultFieldName(args, interval)] = mean.Unwrap()
+			if !args.OmitStdDev {
+				fields[wdStdDevResultFieldName(args, interval)] = stdDev.Unwrap()
+			}
+			fields[wdMeanIntercardinalResultFieldName(args, interval)] = card
+		}
+
+		point, err := args.newPoint(
+			args.intervalMeasurement(interval),
+			args.WriteTags,
+			fields,
+			args.pointTime(args.intervalEnd(now, windDirIntervalToDuration(interval)), windDirIntervalToDuration(interval)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+		if point != nil {
+			retv = append(retv, point)
+		}
+	}
+
+	return retv, nil
+}
+
+// wdModalDirection returns the center of the compass sector, at the given precision,
+// in which the interval's non-calm samples most often fell. As for the wind vector,
+// a sample is calm if its sustained speed is zero, whatever its gust or weight, and
+// a sample without a speed is treated as calm. With WindDirModeTime,
+// each sample counts for the time until the interval's next sample (calm or not);
+// the last sample, and one followed by a gap of several cadences (an outage), count
+// for one cadence. Ties go to the sector nearest north, clockwise. It returns false
+// if every sample is calm.
+func wdModalDirection(data []wdDataPoint, by string, cadence time.Duration, precision libwx.DirectionStrPrecision) (libwx.Degree, bool) {
+	sectors := 4 << (precision - libwx.DirectionStrPrecision1) // 4, 8, or 16
+	width := 360.0 / float64(sectors)
+	totals := make([]float64, sectors)
+	found := false
+	for i, dp := range data {
+		if dp.spd <= 0.001 {
+			continue
+		}
+		found = true
+		w := 1.0
+		if by == WindDirModeTime && cadence > 0 {
+			d := cadence
+			if i+1 < len(data) {
+				if gap := data[i+1].t.Sub(dp.t); gap < passthroughCadenceRatio*cadence {
+					d = gap
+				}
+			}
+			w = d.Seconds()
+		}
+		totals[int(math.Mod(dp.dir.Unwrap()+width/2, 360)/width)%sectors] += w
+	}
+	if !found {
+		return 0, false
+	}
+	best := 0
+	for i, total := range totals {
+		if total > totals[best] {
+			best = i
+		}
+	}
+	return libwx.Degree(float64(best) * width), true
+}
+
+// wdIntervalsWithNewData returns intervalsTodo plus each interval in
+// latestSummarized for which a source sample newer than the newest sample its
+// stored aggregate summarizes has since arrived, in the order of
+// allWindDirectionIntervals.
+func wdIntervalsWithNewData(ctx context.Context, args WindDirectionAggArgs, tagsWhere string, fields []string, parsers map[string]func(v any) (float64, error), intervalsTodo []string, latestSummarized map[string]int64) ([]string, error) {
+	oldest := int64(math.MaxInt64)
+	for _, latest := range latestSummarized {
+		oldest = min(oldest, latest)
+	}
+	samples, err := querySamples(ctx, sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       fields,
+		SourceFields: args.SourceFields,
+		Since:        time.UnixMilli(oldest),
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		Parsers:      parsers,
+		MADExempt:    []string{args.WindDirectionField},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return intervalsTodo, nil
+	}
+	newest := samples[len(samples)-1].t.UnixMilli()
+
+	var retv []string
+	for _, interval := range allWindDirectionIntervals() {
+		latest, checked := latestSummarized[interval]
+		if slices.Contains(intervalsTodo, interval) || (checked && newest > latest) {
+			retv = append(retv, interval)
+		}
+	}
+	return retv, nil
+}
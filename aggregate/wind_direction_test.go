@@ -0,0 +1,201 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cdzombak/libwx"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+func TestCompassDirectionParser(t *testing.T) {
+	points := []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+	for i, s := range points {
+		want := float64(i) * 22.5
+		for _, v := range []string{s, " " + s + " ", string([]rune(s)[0]+'a'-'A') + s[1:]} {
+			got, err := compassDirectionParser(v)
+			if err != nil {
+				t.Fatalf("compassDirectionParser(%q): %v", v, err)
+			}
+			if got != want {
+				t.Errorf("compassDirectionParser(%q) = %v, want %v", v, got, want)
+			}
+		}
+	}
+
+	// unparseable values are missing samples, not errors:
+	for _, v := range []any{"", "NORTH", "NNNE", "VAR", "NIL", "22.5", 22.5, nil} {
+		got, err := compassDirectionParser(v)
+		if err != nil {
+			t.Errorf("compassDirectionParser(%#v): unexpected error %v", v, err)
+		}
+		if !math.IsNaN(got) {
+			t.Errorf("compassDirectionParser(%#v) = %v, want NaN", v, got)
+		}
+	}
+}
+
+func TestNormalizeDirection(t *testing.T) {
+	tests := []struct {
+		v        float64
+		dirRange string
+		want     float64
+		wantOK   bool
+	}{
+		{0, DirRangeAuto, 0, true},
+		{90, DirRangeAuto, 90, true},
+		{360, DirRangeAuto, 0, true},
+		{-90, DirRangeAuto, 270, true},
+		{-180, DirRangeAuto, 180, true},
+		{450, DirRangeAuto, 90, true},
+		{-450, DirRangeAuto, 270, true},
+
+		{0, DirRangeUnsigned, 0, true},
+		{359.9, DirRangeUnsigned, 359.9, true},
+		{360, DirRangeUnsigned, 0, true},
+		{-90, DirRangeUnsigned, 0, false},
+		{360.1, DirRangeUnsigned, 0, false},
+
+		{-90, DirRangeSigned, 270, true},
+		{-180, DirRangeSigned, 180, true},
+		{180, DirRangeSigned, 180, true},
+		{90, DirRangeSigned, 90, true},
+		{-180.1, DirRangeSigned, 0, false},
+		{270, DirRangeSigned, 0, false},
+
+		{math.NaN(), DirRangeAuto, 0, false},
+		{math.NaN(), DirRangeUnsigned, 0, false},
+		{math.NaN(), DirRangeSigned, 0, false},
+		{math.Inf(1), DirRangeAuto, 0, false},
+		{math.Inf(-1), DirRangeAuto, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizeDirection(tt.v, tt.dirRange)
+		if ok != tt.wantOK {
+			t.Errorf("NormalizeDirection(%v, %s) ok = %v, want %v", tt.v, tt.dirRange, ok, tt.wantOK)
+			continue
+		}
+		if ok && math.Abs(got.Unwrap()-tt.want) > 1e-9 {
+			t.Errorf("NormalizeDirection(%v, %s) = %v, want %v", tt.v, tt.dirRange, got.Unwrap(), tt.want)
+		}
+	}
+}
+
+func TestModalDirectionIgnoresCalm(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// weighted by gust: the calm samples have a gust, and so a weight, but no
+	// sustained wind, and mustn't outvote the one moving sample.
+	data := []wdDataPoint{
+		{t: t0, dir: 90, spd: 0, weight: 12},
+		{t: t0.Add(time.Minute), dir: 90, spd: 0, weight: 8},
+		{t: t0.Add(2 * time.Minute), dir: 270, spd: 5, weight: 10},
+		{t: t0.Add(3 * time.Minute), dir: 90},
+	}
+	for _, by := range []string{WindDirModeSamples, WindDirModeTime} {
+		got, ok := wdModalDirection(data, by, time.Minute, libwx.DirectionStrPrecision2)
+		if !ok || got != 270 {
+			t.Errorf("wdModalDirection(%s) = %v, %v; want 270, true", by, got, ok)
+		}
+		if _, ok := wdModalDirection(data[:2], by, time.Minute, libwx.DirectionStrPrecision2); ok {
+			t.Errorf("wdModalDirection(%s) of calm samples: want no direction", by)
+		}
+	}
+}
+
+func TestRotateDirection(t *testing.T) {
+	for _, tt := range []struct {
+		dir    libwx.Degree
+		offset float64
+		want   libwx.Degree
+	}{
+		{355, 10, 5},
+		{350, 10, 0},
+		{90, 0, 90},
+		{90, 720, 90},
+		{5, -10, 355},
+		{0, -90, 270},
+		{180, -180, 0},
+		{10, -370, 0},
+		{45, -765, 0},
+		{0, -1e-15, 0},
+	} {
+		got := RotateDirection(tt.dir, tt.offset)
+		if got != tt.want || math.Signbit(got.Unwrap()) {
+			t.Errorf("RotateDirection(%v, %v) = %v, want %v", tt.dir, tt.offset, got, tt.want)
+		}
+		if got < 0 || got >= 360 {
+			t.Errorf("RotateDirection(%v, %v) = %v, outside [0, 360)", tt.dir, tt.offset, got)
+		}
+	}
+}
+
+// TestWindDirectionAggFrozenClock checks window membership and timestamps against
+// a pinned Now rather than the wall clock.
+func TestWindDirectionAggFrozenClock(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	row := models.Row{Name: "wx", Columns: []string{"time", "wind_dir", "wind_speed"}}
+	for _, s := range []struct {
+		ago time.Duration
+		dir float64
+	}{
+		{7 * time.Hour, 0}, // outside every interval
+		{4 * time.Hour, 270},
+		{45 * time.Minute, 180},
+		{10 * time.Minute, 90},
+		{2 * time.Minute, 90},
+	} {
+		row.Values = append(row.Values, []any{now.Add(-s.ago).Format(time.RFC3339), json.Number(fmt.Sprint(s.dir)), json.Number("5")})
+	}
+	wantSamples := map[string]int64{"5m": 1, "15m": 2, "30m": 2, "1h": 3, "3h": 3, "6h": 4}
+
+	for _, strategy := range []string{TimestampTrailing, TimestampCentered} {
+		client := &scriptedClient{rows: map[string]models.Row{"SELECT time, wind_dir, wind_speed FROM": row}}
+		args := WindDirectionAggArgs{
+			CommonArgs: CommonArgs{
+				MeasurementFrom:   "wx",
+				MeasurementTo:     "wx_agg",
+				TimestampStrategy: strategy,
+				Now:               func() time.Time { return now },
+			},
+			Store:              Store{Influx: client},
+			WindDirectionField: "wind_dir",
+			WindSpeedField:     "wind_speed",
+		}
+		points, err := WindDirectionAgg(context.Background(), args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != len(wantSamples) {
+			t.Fatalf("%s: got %d points, want %d", strategy, len(points), len(wantSamples))
+		}
+		for _, p := range points {
+			fields, err := p.Fields()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var interval string
+			for i := range wantSamples {
+				if _, ok := fields[wdSamplesResultFieldName(args, i)]; ok {
+					interval = i
+				}
+			}
+			if interval == "" {
+				t.Fatalf("%s: point has no samples field: %v", strategy, fields)
+			}
+			if got := fields[wdSamplesResultFieldName(args, interval)]; got != wantSamples[interval] {
+				t.Errorf("%s %s: samples = %v, want %d", strategy, interval, got, wantSamples[interval])
+			}
+			wantTime := now
+			if strategy == TimestampCentered {
+				wantTime = now.Add(-windDirIntervalToDuration(interval) / 2)
+			}
+			if !p.Time().Equal(wantTime) {
+				t.Errorf("%s %s: timestamp %s, want %s", strategy, interval, p.Time(), wantTime)
+			}
+		}
+	}
+}
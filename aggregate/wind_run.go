@@ -0,0 +1,170 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type WindRunAggArgs struct {
+	CommonArgs
+	Store
+
+	WindSpeedField string
+	WindSpeedUnit  WindSpeedUnit // unit of WindSpeedField; defaults to mph
+}
+
+const (
+	wrInterval24h = "24h"
+	wrInterval1h  = "1h"
+
+	// windRunMaxGap is the longest gap between samples over which speed is integrated;
+	// air movement during a longer gap (e.g. a station outage) is unknown, not calm.
+	windRunMaxGap = 15 * time.Minute
+)
+
+func allWindRunIntervals() []string {
+	return []string{wrInterval24h, wrInterval1h}
+}
+
+func windRunIntervalToDuration(interval string) time.Duration {
+	switch interval {
+	case wrInterval24h:
+		return 24 * time.Hour
+	case wrInterval1h:
+		return time.Hour
+	default:
+		panic(fmt.Sprintf("unknown wind run interval: %s", interval))
+	}
+}
+
+func wrResultFieldName(args WindRunAggArgs, interval string) string {
+	return args.intervalFieldName("wind_run", interval)
+}
+
+// FieldUnits returns the units of this aggregation's output fields which have one.
+func (args WindRunAggArgs) FieldUnits() FieldUnits {
+	speedUnit := args.WindSpeedUnit
+	if speedUnit == "" {
+		speedUnit = WindSpeedMph
+	}
+	return FieldUnits{"wind_run": Unit(speedUnit.DistanceUnit())}
+}
+
+type wrDataPoint struct {
+	t   time.Time
+	spd float64 // in the source unit
+}
+
+// DistanceUnit returns the unit of distance traveled at this speed per hour, in which
+// wind run is reported: miles, kilometers, or nautical miles. Wind recorded in m/s is
+// reported in kilometers, since a day's wind run is typically hundreds of them.
+func (u WindSpeedUnit) DistanceUnit() string {
+	switch u {
+	case WindSpeedKmH, WindSpeedMps:
+		return "km"
+	case WindSpeedKnots:
+		return "nmi"
+	default:
+		return "mi"
+	}
+}
+
+// accumWindRun integrates wind speed over time using the trapezoidal rule and actual
+// sample spacing, returning the distance traveled in the speed's distance unit. The
+// segment between two samples more than windRunMaxGap apart contributes nothing.
+func accumWindRun(data []wrDataPoint, unit WindSpeedUnit) float64 {
+	total := 0.0
+	for i := 1; i < len(data); i++ {
+		dt := data[i].t.Sub(data[i-1].t)
+		if dt <= 0 || dt > windRunMaxGap {
+			continue
+		}
+		total += (data[i-1].spd + data[i].spd) / 2 * dt.Hours()
+	}
+	if unit == WindSpeedMps {
+		total *= 3.6 // m/s·h to km
+	}
+	return total
+}
+
+func WindRunAgg(ctx context.Context, args WindRunAggArgs) ([]*influxdb.Point, error) {
+	// note: the given args are assumed to be valid.
+	// if this were a real project or API that other people would use, I'd validate them here.
+
+	speedUnit := args.WindSpeedUnit
+	if speedUnit == "" {
+		speedUnit = WindSpeedMph
+	}
+
+	tagsWhere := PartialWhereClauseForTags(args.QueryTags)
+
+	// query for the longest interval; shorter intervals will filter from this data.
+	samples, err := querySamples(ctx, args.alignQuery(sampleQuery{
+		Store:        args.Store,
+		Measurement:  args.MeasurementFrom,
+		Fields:       []string{args.WindSpeedField},
+		SourceFields: args.SourceFields,
+		Window:       wrInterval24h,
+		TagsWhere:    tagsWhere + args.SourceFilter,
+		Filter:       args.Filter,
+		Summary:      args.Summary,
+	}, intervalDurations(allWindRunIntervals(), windRunIntervalToDuration)...))
+	if err != nil {
+		return nil, err
+	}
+
+	var allData []wrDataPoint
+	for _, s := range samples {
+		if math.IsNaN(s.values[0]) {
+			continue
+		}
+		allData = append(allData, wrDataPoint{t: s.t, spd: math.Max(s.values[0], 0)})
+	}
+
+	if len(allData) == 0 {
+		return nil, args.emptyResult("no wind speed data to aggregate")
+	}
+
+	args.Summary.AddSamplesRead(len(allData))
+	warnPassthroughIntervals("wind speed", samples, allWindRunIntervals()...)
+
+	latestTime := allData[len(allData)-1].t
+	var retv []*influxdb.Point
+
+	for _, interval := range allWindRunIntervals() {
+		dur := windRunIntervalToDuration(interval)
+
+		var intervalData []wrDataPoint
+		for _, dp := range allData {
+			if args.inInterval(latestTime, dp.t, dur) {
+				intervalData = append(intervalData, dp)
+			}
+		}
+
+		if len(intervalData) < 2 || !args.enoughSamples("wind_run", interval, len(intervalData)) {
+			continue
+		}
+
+		p, err := args.newPoint(
+			args.intervalMeasurement(interval),
+			args.WriteTags,
+			map[string]any{
+				wrResultFieldName(args, interval): accumWindRun(intervalData, speedUnit),
+			},
+			args.pointTime(args.intervalEnd(intervalData[len(intervalData)-1].t, dur), dur),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+		if p != nil {
+			retv = append(retv, p)
+		}
+		args.Summary.RecordIntervals("wind_run", []string{interval})
+	}
+
+	return retv, nil
+}
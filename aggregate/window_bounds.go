@@ -0,0 +1,69 @@
+package aggregate
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// WindowBoundsPoints returns the given aggregate points with two more fields
+// recording the window each point summarizes, "window_start_<interval>" and
+// "window_end_<interval>" (RFC 3339 strings), derived from the point's timestamp
+// per args.TimestampStrategy. The fields are named per args.Layout and
+// args.FieldSuffix, like the aggregates. Points without exactly one interval, such
+// as the rain rate and event total, are unchanged.
+func WindowBoundsPoints(points []*influxdb.Point, args CommonArgs) ([]*influxdb.Point, error) {
+	retv := make([]*influxdb.Point, len(points))
+	for i, p := range points {
+		pFields, err := p.Fields()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read point fields: %w", err)
+		}
+		interval, ok := pointInterval(p.Name(), pFields, args.FieldSuffix)
+		if !ok {
+			retv[i] = p
+			continue
+		}
+		end := args.windowEnd(p.Time(), interval)
+		fields := maps.Clone(pFields) // p caches its fields, so they must not be modified
+		fields[args.intervalFieldName("window_start", intervalName(interval))] = end.Add(-interval).UTC().Format(time.RFC3339)
+		fields[args.intervalFieldName("window_end", intervalName(interval))] = end.UTC().Format(time.RFC3339)
+		retv[i], err = influxdb.NewPoint(p.Name(), p.Tags(), fields, p.Time())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+	}
+	return retv, nil
+}
+
+// pointInterval returns the interval an aggregate point summarizes: the interval
+// in its measurement name (in the measurement-per-interval layout), or else the one
+// interval shared by all its interval-suffixed fields.
+func pointInterval(measurement string, fields map[string]any, fieldSuffix string) (time.Duration, bool) {
+	if _, interval := splitIntervalFieldName(measurement); interval != 0 {
+		return interval, true
+	}
+	var retv time.Duration
+	for name := range fields {
+		_, interval := splitIntervalFieldName(strings.TrimSuffix(name, fieldSuffix))
+		if interval == 0 {
+			continue
+		}
+		if retv != 0 && interval != retv {
+			return 0, false
+		}
+		retv = interval
+	}
+	return retv, retv != 0
+}
+
+// intervalName formats an interval as in field names, e.g. "5m" or "24h".
+func intervalName(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
@@ -0,0 +1,70 @@
+package aggregate
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// AllIntervals returns every interval over which any aggregate is computed,
+// shortest first.
+func AllIntervals() []string {
+	var retv []string
+	for _, interval := range slices.Concat(
+		allWindDirectionIntervals(), allWindRunIntervals(), allRainIntervals(), allSoilIntervals(),
+		allNumericIntervals(), allHealthIntervals(),
+	) {
+		if !slices.Contains(retv, interval) {
+			retv = append(retv, interval)
+		}
+	}
+	slices.SortFunc(retv, func(a, b string) int {
+		da, _ := time.ParseDuration(a)
+		db, _ := time.ParseDuration(b)
+		return int(da - db)
+	})
+	return retv
+}
+
+// KeepIntervals returns the given aggregate points with only the given intervals'
+// aggregates: fields (or, in the measurement-per-interval layout, whole points) for
+// other intervals are removed, as are points left with no fields. Fields without an
+// interval, like "rain_rate", are always kept.
+func KeepIntervals(points []*influxdb.Point, intervals []time.Duration, fieldSuffix string) ([]*influxdb.Point, error) {
+	var retv []*influxdb.Point
+	for _, p := range points {
+		if _, interval := splitIntervalFieldName(p.Name()); interval != 0 {
+			if slices.Contains(intervals, interval) {
+				retv = append(retv, p)
+			}
+			continue
+		}
+
+		pFields, err := p.Fields()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read point fields: %w", err)
+		}
+		fields := maps.Clone(pFields) // p caches its fields, so they must not be modified
+		maps.DeleteFunc(fields, func(name string, _ any) bool {
+			_, interval := splitIntervalFieldName(strings.TrimSuffix(name, fieldSuffix))
+			return interval != 0 && !slices.Contains(intervals, interval)
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == len(pFields) {
+			retv = append(retv, p)
+			continue
+		}
+		kept, err := influxdb.NewPoint(p.Name(), p.Tags(), fields, p.Time())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+		}
+		retv = append(retv, kept)
+	}
+	return retv, nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Aggregator computes one kind of aggregate from source data.
+type Aggregator interface {
+	// Name is the name by which the aggregation may be referred to in flags (e.g. -filter).
+	Name() string
+	// Run computes the aggregation, returning the points to write.
+	Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error)
+}
+
+// unitAggregator is implemented by aggregators with output fields that have a
+// physical unit, which -dual-units converts.
+type unitAggregator interface {
+	FieldUnits() aggregate.FieldUnits
+}
+
+// dependentAggregator is implemented by aggregators which compute aggregates from
+// other aggregates, rather than from source data.
+type dependentAggregator interface {
+	Aggregator
+	// withInputs returns the aggregator, given the points computed earlier in the
+	// run, which it reads in addition to those already stored.
+	withInputs(points []*influxdb.Point) Aggregator
+}
+
+// aggregatorRegistry lists every aggregator, in the order they run. Each entry's
+// build func returns nil if the aggregation isn't enabled by the given config.
+// A dependentAggregator consumes the output of those before it, so it must follow
+// every aggregator whose output it may read.
+var aggregatorRegistry = []struct {
+	name   string
+	window time.Duration // the widest window of source data it reads
+	build  func(cfg *Config) Aggregator
+}{
+	{"wind", 6 * time.Hour, func(cfg *Config) Aggregator {
+		if cfg.WindDirectionField == "" {
+			return nil
+		}
+		compassPrecision, _ := aggregate.CompassPrecisionFromPoints(cfg.CompassPoints) // validated by Config.Validate
+		windSpeedUnit, _ := aggregate.ParseWindSpeedUnit(cfg.WindSpeedUnit)
+		return windAggregator{aggregate.WindDirectionAggArgs{
+			WindDirectionField:  cfg.WindDirectionField,
+			WindDirectionFormat: cfg.WindDirectionFormat,
+			DirectionRange:      cfg.DirectionRange,
+			DirectionOffset:     cfg.DirectionOffset,
+			WindSpeedField:      cfg.WindSpeedField,
+			WindSpeedUnit:       windSpeedUnit,
+			WindGustField:       cfg.WindGustField,
+			WeightBy:            cfg.WeightBy,
+			WeightDecay:         cfg.WeightDecay,
+			IncludeUnpaired:     !cfg.RequirePaired,
+			RecomputeOn:         cfg.RecomputeOn,
+			ModeBy:              cfg.WindDirMode,
+			VarHysteresis:       cfg.VarHysteresis,
+			CompassPrecision:    compassPrecision,
+			OmitStdDev:          !cfg.EmitStdDev,
+		}}
+	}},
+	{"wind_run", 24 * time.Hour, func(cfg *Config) Aggregator {
+		if !cfg.WindRun {
+			return nil
+		}
+		windSpeedUnit, _ := aggregate.ParseWindSpeedUnit(cfg.WindSpeedUnit)
+		return windRunAggregator{aggregate.WindRunAggArgs{WindSpeedField: cfg.WindSpeedField, WindSpeedUnit: windSpeedUnit}}
+	}},
+	{"rain", 24 * time.Hour, func(cfg *Config) Aggregator {
+		if cfg.RainField == "" {
+			return nil
+		}
+		return rainAggregator{"rain", aggregate.RainAggArgs{RainField: cfg.RainField, ResetThreshold: cfg.RainResetThreshold, BootstrapLookback: cfg.RainBootstrapLookback}}
+	}},
+	{"rain2", 24 * time.Hour, func(cfg *Config) Aggregator {
+		if cfg.Rain2Field == "" {
+			return nil
+		}
+		return rainAggregator{"rain2", aggregate.RainAggArgs{RainField: cfg.Rain2Field, OutputPrefix: cfg.Rain2Prefix, ResetThreshold: cfg.RainResetThreshold, BootstrapLookback: cfg.RainBootstrapLookback}}
+	}},
+	{"altimeter", time.Hour, func(cfg *Config) Aggregator {
+		if cfg.PressureField == "" {
+			return nil
+		}
+		return altimeterAggregator{aggregate.AltimeterAggArgs{PressureField: cfg.PressureField, AltitudeMeters: cfg.Altitude}}
+	}},
+	{"lightning", time.Hour, func(cfg *Config) Aggregator {
+		if cfg.LightningCountField == "" {
+			return nil
+		}
+		return lightningAggregator{aggregate.LightningAggArgs{
+			CountField:       cfg.LightningCountField,
+			DistanceField:    cfg.LightningDistanceField,
+			NearestTimeAsTag: cfg.ExtremeTimeAsTag,
+		}}
+	}},
+	{"soil", 24 * time.Hour, func(cfg *Config) Aggregator {
+		if len(cfg.SoilFields) == 0 {
+			return nil
+		}
+		return soilAggregator{aggregate.SoilAggArgs{Fields: cfg.SoilFields}}
+	}},
+	{"air_quality", time.Hour, func(cfg *Config) Aggregator {
+		if cfg.PM25Field == "" {
+			return nil
+		}
+		return airQualityAggregator{aggregate.AirQualityAggArgs{PM25Field: cfg.PM25Field, AQICategory: cfg.AQICategory}}
+	}},
+	{"dewpoint", time.Hour, func(cfg *Config) Aggregator {
+		if cfg.TempField == "" {
+			return nil
+		}
+		tempUnit, _ := aggregate.ParseTempUnit(cfg.TempUnit)
+		return dewpointAggregator{aggregate.DewpointAggArgs{
+			TempField:     cfg.TempField,
+			TempUnit:      tempUnit,
+			HumidityField: cfg.HumidityField,
+			DewpointField: cfg.DewpointField,
+			Check:         cfg.DewpointCheck,
+		}}
+	}},
+	{"numeric", 24 * time.Hour, func(cfg *Config) Aggregator {
+		if len(cfg.NumericFields) == 0 {
+			return nil
+		}
+		return numericAggregator{aggregate.NumericAggArgs{Fields: cfg.NumericFields}}
+	}},
+	{"health", 24 * time.Hour, func(cfg *Config) Aggregator {
+		if cfg.BatteryField == "" && cfg.SignalField == "" {
+			return nil
+		}
+		return healthAggregator{aggregate.HealthAggArgs{
+			BatteryField:        cfg.BatteryField,
+			BatteryType:         cfg.BatteryType,
+			BatteryLowThreshold: cfg.BatteryLowThreshold,
+			BatteryLowValues:    cfg.BatteryLowValues,
+			SignalField:         cfg.SignalField,
+		}}
+	}},
+	{"stuck", time.Hour, func(cfg *Config) Aggregator {
+		if len(cfg.StuckFields) == 0 {
+			return nil
+		}
+		return stuckAggregator{aggregate.StuckAggArgs{Fields: cfg.StuckFields, MinSamples: cfg.StuckMinSamples}}
+	}},
+	// reads stored aggregates, not source data, and must run after every aggregator above:
+	{"rollup", 0, func(cfg *Config) Aggregator {
+		if len(cfg.Rollups) == 0 {
+			return nil
+		}
+		return rollupAggregator{args: aggregate.RollupAggArgs{Rollups: cfg.Rollups}}
+	}},
+}
+
+// widestEnabledWindow returns the widest window of source data read by any
+// aggregator enabled by the given config, or 0 if none are enabled.
+func widestEnabledWindow(cfg *Config) time.Duration {
+	var retv time.Duration
+	for _, r := range aggregatorRegistry {
+		if r.build(cfg) != nil {
+			retv = max(retv, r.window)
+		}
+	}
+	if cfg.WindowType == aggregate.WindowTumbling || len(cfg.Periods) > 0 {
+		// the last complete fixed window may have ended up to a window ago:
+		retv *= 2
+	}
+	return retv
+}
+
+// enabledFieldUnits returns the units of every output field with one, from the
+// aggregators enabled by the given config and its raw source fields.
+func enabledFieldUnits(cfg *Config) aggregate.FieldUnits {
+	retv := cfg.rawFieldUnits()
+	for _, agg := range EnabledAggregators(cfg) {
+		if ua, ok := agg.(unitAggregator); ok {
+			maps.Copy(retv, ua.FieldUnits())
+		}
+	}
+	return retv
+}
+
+// allAggregationNames returns the names by which each aggregation may be referred to in flags.
+func allAggregationNames() []string {
+	retv := make([]string, len(aggregatorRegistry))
+	for i, r := range aggregatorRegistry {
+		retv[i] = r.name
+	}
+	return retv
+}
+
+// EnabledAggregators returns the aggregators enabled by the given config, in the order they should run.
+func EnabledAggregators(cfg *Config) []Aggregator {
+	var retv []Aggregator
+	for _, r := range aggregatorRegistry {
+		if agg := r.build(cfg); agg != nil {
+			retv = append(retv, agg)
+		}
+	}
+	return retv
+}
+
+type windAggregator struct {
+	args aggregate.WindDirectionAggArgs
+}
+
+func (a windAggregator) Name() string { return "wind" }
+
+func (a windAggregator) FieldUnits() aggregate.FieldUnits { return a.args.FieldUnits() }
+
+func (a windAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.WindDirectionAgg(ctx, a.args)
+}
+
+type windRunAggregator struct{ args aggregate.WindRunAggArgs }
+
+func (a windRunAggregator) Name() string { return "wind_run" }
+
+func (a windRunAggregator) FieldUnits() aggregate.FieldUnits { return a.args.FieldUnits() }
+
+func (a windRunAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.WindRunAgg(ctx, a.args)
+}
+
+type rainAggregator struct {
+	name string
+	args aggregate.RainAggArgs
+}
+
+func (a rainAggregator) Name() string { return a.name }
+
+func (a rainAggregator) FieldUnits() aggregate.FieldUnits { return a.args.FieldUnits() }
+
+func (a rainAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.RainAgg(ctx, a.args)
+}
+
+type altimeterAggregator struct{ args aggregate.AltimeterAggArgs }
+
+func (a altimeterAggregator) Name() string { return "altimeter" }
+
+func (a altimeterAggregator) FieldUnits() aggregate.FieldUnits { return a.args.FieldUnits() }
+
+func (a altimeterAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.AltimeterAgg(ctx, a.args)
+}
+
+type lightningAggregator struct{ args aggregate.LightningAggArgs }
+
+func (a lightningAggregator) Name() string { return "lightning" }
+
+func (a lightningAggregator) FieldUnits() aggregate.FieldUnits { return a.args.FieldUnits() }
+
+func (a lightningAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.LightningAgg(ctx, a.args)
+}
+
+type soilAggregator struct{ args aggregate.SoilAggArgs }
+
+func (a soilAggregator) Name() string { return "soil" }
+
+func (a soilAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.SoilAgg(ctx, a.args)
+}
+
+type airQualityAggregator struct{ args aggregate.AirQualityAggArgs }
+
+func (a airQualityAggregator) Name() string { return "air_quality" }
+
+func (a airQualityAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.AirQualityAgg(ctx, a.args)
+}
+
+type dewpointAggregator struct{ args aggregate.DewpointAggArgs }
+
+func (a dewpointAggregator) Name() string { return "dewpoint" }
+
+func (a dewpointAggregator) FieldUnits() aggregate.FieldUnits { return a.args.FieldUnits() }
+
+func (a dewpointAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.DewpointAgg(ctx, a.args)
+}
+
+type numericAggregator struct{ args aggregate.NumericAggArgs }
+
+func (a numericAggregator) Name() string { return "numeric" }
+
+func (a numericAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.NumericAgg(ctx, a.args)
+}
+
+type healthAggregator struct{ args aggregate.HealthAggArgs }
+
+func (a healthAggregator) Name() string { return "health" }
+
+func (a healthAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.HealthAgg(ctx, a.args)
+}
+
+type stuckAggregator struct{ args aggregate.StuckAggArgs }
+
+func (a stuckAggregator) Name() string { return "stuck" }
+
+func (a stuckAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.StuckAgg(ctx, a.args)
+}
+
+type rollupAggregator struct {
+	args   aggregate.RollupAggArgs
+	inputs []*influxdb.Point
+}
+
+func (a rollupAggregator) Name() string { return "rollup" }
+
+func (a rollupAggregator) withInputs(points []*influxdb.Point) Aggregator {
+	a.inputs = points
+	return a
+}
+
+func (a rollupAggregator) Run(ctx context.Context, store aggregate.Store, common aggregate.CommonArgs) ([]*influxdb.Point, error) {
+	a.args.Store, a.args.CommonArgs = store, common
+	return aggregate.RollupAgg(ctx, a.args, a.inputs)
+}
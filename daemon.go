@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// runDaemon runs repeatedly, every -daemon-interval, until interrupted. Computed
+// points are buffered and written at most once per -min-write-interval; any points
+// still buffered are written before it returns. A failed run is logged and the next
+// run proceeds as scheduled. It returns an error only if the final write fails.
+func runDaemon(r *runner) error {
+	cfg := r.cfg
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(cfg.DaemonInterval)
+	defer ticker.Stop()
+
+	var buf writeBuffer
+	var lastWrite time.Time
+
+	for {
+		summary := aggregate.NewRunSummary(time.Now())
+		// a signal lets the current run finish, so its context isn't derived from ctx:
+		runCtx, cancelRun := runContext(cfg)
+		points, err := r.computeAll(runCtx, summary)
+		if err != nil {
+			errLog.Println(err)
+			summary.AddError()
+			r.dumpDiagnostics(summary, err)
+		} else if err := buf.Add(points...); err != nil {
+			errLog.Printf("failed to buffer points: %s", err)
+			summary.AddError()
+		}
+
+		if buf.Len() > 0 {
+			if wait := cfg.MinWriteInterval - time.Since(lastWrite); wait > 0 {
+				log.Printf("buffering %d points; next write in %s", buf.Len(), wait.Round(time.Second))
+			} else if runCtx.Err() != nil {
+				log.Printf("run exceeded -run-timeout; buffering %d points for the next write", buf.Len())
+			} else {
+				n, err := r.flush(&buf, summary)
+				summary.PointsWritten = n
+				if err != nil {
+					errLog.Printf("write failed: %s", err)
+					summary.AddError()
+				} else {
+					lastWrite = time.Now()
+				}
+			}
+		}
+
+		cancelRun()
+		logRunTimings(summary)
+		if cfg.ShowSummary {
+			errLog.Printf("summary: %s", summary)
+		}
+		r.emitRunMetadata(summary)
+		r.runs++
+
+		select {
+		case <-ctx.Done():
+			if buf.Len() == 0 {
+				return nil
+			}
+			log.Printf("writing %d buffered points before exiting", buf.Len())
+			_, err := r.flush(&buf, nil)
+			if err != nil {
+				errLog.Printf("write failed: %s", err)
+			}
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// due reports whether the named aggregation runs in the current run: every run,
+// or, with a -schedule, every schedule/-daemon-interval runs, starting with the first.
+// Runs are counted rather than timed, so a run delayed by a slow one doesn't shift
+// the schedule, and a run skipped entirely delays it by one run.
+func (r *runner) due(aggName string) bool {
+	every, ok := r.cfg.Schedules[aggName]
+	if !ok || r.cfg.DaemonInterval == 0 {
+		return true
+	}
+	return r.runs%int(every/r.cfg.DaemonInterval) == 0
+}
+
+// flush writes (or, in dry-run mode, prints) the buffered points, returning the
+// number written. The buffer is kept for the next attempt only if the write failed
+// transiently; points InfluxDB rejected would just be rejected again. The write's
+// duration is recorded in summary, which may be nil.
+func (r *runner) flush(buf *writeBuffer, summary *aggregate.RunSummary) (int, error) {
+	points := buf.Points()
+	if r.cfg.DryRun {
+		printPoints(r.cfg, points)
+		buf.Reset()
+		return 0, nil
+	}
+	err := writePoints(timedClient{r.client, summary}, r.cfg, points)
+	if err == nil {
+		buf.Reset()
+		return len(points), nil
+	}
+	// writes aren't bound to a run's context; a timed-out write is retried next flush:
+	if !isTransient(context.Background(), err) {
+		buf.Reset()
+	}
+	var partialErr *PartialWriteError
+	if errors.As(err, &partialErr) && partialErr.Dropped > 0 {
+		return partialErr.Written(), err
+	}
+	return 0, err
+}
+
+// writeBuffer accumulates points between writes in daemon mode. Points with the
+// same measurement, tags, and timestamp are merged, later field values replacing
+// earlier ones, just as InfluxDB would merge them had they been written separately.
+// A tumbling window or period recomputed while its earlier result is still buffered
+// replaces that result, since its timestamp is fixed; a sliding window's timestamp
+// moves with every run, so each of its results is kept and written.
+type writeBuffer struct {
+	points []*influxdb.Point
+	index  map[string]int // seriesTimeKey -> index into points
+}
+
+func (b *writeBuffer) Add(points ...*influxdb.Point) error {
+	if b.index == nil {
+		b.index = make(map[string]int)
+	}
+	for _, p := range points {
+		key := seriesTimeKey(p)
+		i, ok := b.index[key]
+		if !ok {
+			b.index[key] = len(b.points)
+			b.points = append(b.points, p)
+			continue
+		}
+		oldFields, err := b.points[i].Fields()
+		if err != nil {
+			return err
+		}
+		fields := maps.Clone(oldFields) // points cache their fields, so they must not be modified
+		newFields, err := p.Fields()
+		if err != nil {
+			return err
+		}
+		maps.Copy(fields, newFields)
+		merged, err := influxdb.NewPoint(p.Name(), p.Tags(), fields, p.Time())
+		if err != nil {
+			return err
+		}
+		b.points[i] = merged
+	}
+	return nil
+}
+
+func (b *writeBuffer) Len() int { return len(b.points) }
+
+func (b *writeBuffer) Points() []*influxdb.Point { return b.points }
+
+func (b *writeBuffer) Reset() {
+	b.points = nil
+	b.index = nil
+}
+
+// seriesTimeKey identifies a point's series (measurement and tags) and timestamp.
+func seriesTimeKey(p *influxdb.Point) string {
+	tags := p.Tags()
+	tagKeys := make([]string, 0, len(tags))
+	for k := range tags {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+
+	var sb strings.Builder
+	sb.WriteString(p.Name())
+	for _, k := range tagKeys {
+		sb.WriteString("\x00" + k + "=" + tags[k])
+	}
+	sb.WriteString("\x00" + strconv.FormatInt(p.UnixNano(), 10))
+	return sb.String()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// diagResponseLimit is the number of bytes of each raw query response kept for a
+// diagnostics dump.
+const diagResponseLimit = 4096
+
+// diagQuery is one query issued during a run, as recorded for a diagnostics dump.
+type diagQuery struct {
+	query    string
+	response string // raw JSON, truncated to diagResponseLimit
+	rows     int    // rows returned, across all series
+	err      error
+}
+
+// diagRecorder records each query issued through it, and its response, so that a
+// failed run can be dumped to -diag-dir. Writes and other requests pass through.
+type diagRecorder struct {
+	influxdb.Client
+	queries []diagQuery
+}
+
+func (d *diagRecorder) Query(q influxdb.Query) (*influxdb.Response, error) {
+	return d.QueryContext(context.Background(), q)
+}
+
+func (d *diagRecorder) QueryContext(ctx context.Context, q influxdb.Query) (*influxdb.Response, error) {
+	r, err := aggregate.QueryWithContext(ctx, d.Client, q)
+	dq := diagQuery{query: q.Command, err: err}
+	if r != nil {
+		if b, jsonErr := json.Marshal(r); jsonErr == nil {
+			dq.response = string(b)
+			if len(dq.response) > diagResponseLimit {
+				dq.response = fmt.Sprintf("%s... (%d bytes truncated)", dq.response[:diagResponseLimit], len(dq.response)-diagResponseLimit)
+			}
+		}
+		for _, result := range r.Results {
+			for _, series := range result.Series {
+				dq.rows += len(series.Values)
+			}
+		}
+	}
+	d.queries = append(d.queries, dq)
+	return r, err
+}
+
+// QueryAsChunk records a chunked query and whether it could be started; its
+// response is streamed to the caller, so it isn't recorded.
+func (d *diagRecorder) QueryAsChunk(q influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	return d.QueryAsChunkContext(context.Background(), q)
+}
+
+func (d *diagRecorder) QueryAsChunkContext(ctx context.Context, q influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	r, err := aggregate.QueryAsChunkWithContext(ctx, d.Client, q)
+	d.queries = append(d.queries, diagQuery{query: q.Command, response: "(chunked; not recorded)", err: err})
+	return r, err
+}
+
+// reset forgets the queries recorded so far, at the start of a run.
+func (d *diagRecorder) reset() {
+	d.queries = nil
+}
+
+// dumpDiagnostics writes a diagnostics file for a failed run to -diag-dir, if set,
+// recording the configuration, the run's queries and their (truncated) responses,
+// sample counts, and the error. Secrets are redacted. Failures are logged but
+// otherwise ignored, since the run has already failed.
+func (r *runner) dumpDiagnostics(summary *aggregate.RunSummary, runErr error) {
+	if r.diag == nil {
+		return
+	}
+	now := time.Now().UTC()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s version %s diagnostics\n", ProductName, Version)
+	fmt.Fprintf(&buf, "time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&buf, "error: %s\n", runErr)
+	fmt.Fprintf(&buf, "samples read: %d; samples dropped: %d\n\n", summary.SamplesRead, summary.SamplesDropped)
+
+	buf.WriteString("configuration:\n")
+	r.cfg.Print(&buf)
+
+	fmt.Fprintf(&buf, "\nqueries (%d):\n", len(r.diag.queries))
+	for i, q := range r.diag.queries {
+		fmt.Fprintf(&buf, "\n[%d] %s\n", i+1, q.query)
+		if q.err != nil {
+			fmt.Fprintf(&buf, "error: %s\n", q.err)
+		}
+		fmt.Fprintf(&buf, "rows: %d\n", q.rows)
+		if q.response != "" {
+			fmt.Fprintf(&buf, "response: %s\n", q.response)
+		}
+	}
+
+	if err := os.MkdirAll(r.cfg.DiagDir, 0o700); err != nil {
+		errLog.Printf("failed to write diagnostics: %s", err)
+		return
+	}
+	name := fmt.Sprintf("%s-diag-%s.txt", strings.ReplaceAll(r.cfg.Measurement, string(filepath.Separator), "_"), now.Format("20060102T150405Z"))
+	path := filepath.Join(r.cfg.DiagDir, name)
+	// the dump may include station data, so it's readable only by its owner:
+	if err := os.WriteFile(path, []byte(Redact(buf.String())), 0o600); err != nil {
+		errLog.Printf("failed to write diagnostics: %s", err)
+		return
+	}
+	log.Printf("wrote diagnostics to %s", path)
+}
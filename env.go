@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type envVarKind int
+
+const (
+	envPlain  envVarKind = iota
+	envURL               // may embed a password, which is redacted
+	envSecret            // never printed
+)
+
+// configEnvVars lists every environment variable this program reads, in the order
+// they're documented in the README.
+var configEnvVars = []struct {
+	name string
+	kind envVarKind
+}{
+	{"INFLUX_SERVER", envURL},
+	{"INFLUX_SERVER_FILE", envPlain},
+	{"INFLUX_USERNAME", envPlain},
+	{"INFLUX_USERNAME_FILE", envPlain},
+	{"INFLUX_PASSWORD", envSecret},
+	{"INFLUX_PASSWORD_FILE", envPlain},
+	{"INFLUX_TOKEN", envSecret},
+	{"INFLUX_TOKEN_FILE", envPlain},
+	{"INFLUX_DB", envPlain},
+	{"INFLUX_DB_FILE", envPlain},
+	{"INFLUX_SRC_DB", envPlain},
+	{"INFLUX_SRC_DB_FILE", envPlain},
+	{"INFLUX_DST_DB", envPlain},
+	{"INFLUX_DST_DB_FILE", envPlain},
+	{"INFLUX_RP", envPlain},
+	{"INFLUX_RP_FILE", envPlain},
+	{"INFLUX_RP_ARCHIVE", envPlain},
+	{"INFLUX_RP_ARCHIVE_FILE", envPlain},
+	{"INFLUX_TLS_SKIP_VERIFY", envPlain},
+	{"INFLUX_TLS_CA_FILE", envPlain},
+	{"INFLUX_TLS_CERT_FILE", envPlain},
+	{"INFLUX_TLS_KEY_FILE", envPlain},
+	{"DAEMON_INTERVAL", envPlain},
+	{"HTTP_PROXY", envURL},
+	{"HTTPS_PROXY", envURL},
+	{"NO_PROXY", envPlain},
+}
+
+// envOrFile returns the value of the named environment variable or, per the common
+// convention for mounted secrets, the contents of the file named by <name>_FILE,
+// less any trailing newline. It's an error for both to be set.
+func envOrFile(name string) (string, error) {
+	val, valSet := os.LookupEnv(name)
+	path, pathSet := os.LookupEnv(name + "_FILE")
+	if !pathSet {
+		return val, nil
+	}
+	if valSet {
+		return "", fmt.Errorf("%s and %s_FILE are both set; set only one", name, name)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+// processEnvVars returns the set of configEnvVars set in the process environment,
+// i.e. before any -env file is loaded.
+func processEnvVars() map[string]bool {
+	retv := make(map[string]bool)
+	for _, v := range configEnvVars {
+		if _, ok := os.LookupEnv(v.name); ok {
+			retv[v.name] = true
+		}
+	}
+	return retv
+}
+
+// PrintEnv writes each environment variable this program reads, its value (with
+// secrets redacted), and where it was set: the process environment, the -env file,
+// or not at all.
+func (c *Config) PrintEnv(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "VARIABLE\tSET\tVALUE")
+	for _, v := range configEnvVars {
+		val, ok := os.LookupEnv(v.name)
+		set := "no"
+		if ok {
+			set = "environment"
+			if !c.envFromProcess[v.name] {
+				set = "env file"
+			}
+		}
+		switch v.kind {
+		case envURL:
+			val = RedactURL(val)
+		case envSecret:
+			val = redactedIfSet(val)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", v.name, set, val)
+	}
+	_ = tw.Flush()
+}
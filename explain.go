@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+var errExplainMode = errors.New("not supported in explain mode")
+
+// explainClient is an influxdb.Client which prints each query instead of executing
+// it, responding as though the query returned no data. Every freshness check
+// therefore finds its interval stale, so the printed source queries cover the
+// widest windows a run could read.
+type explainClient struct {
+	w io.Writer
+
+	lastDB string
+	lastRP string
+}
+
+// NewExplainClient returns an influxdb.Client which writes each query to w, in a
+// form that can be pasted into the influx CLI, without executing it.
+func NewExplainClient(w io.Writer) influxdb.Client {
+	return &explainClient{w: w}
+}
+
+func (c *explainClient) Ping(time.Duration) (time.Duration, string, error) {
+	return 0, "", nil
+}
+
+func (c *explainClient) Write(influxdb.BatchPoints) error {
+	return fmt.Errorf("write: %w", errExplainMode)
+}
+
+func (c *explainClient) QueryAsChunk(q influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	if _, err := c.Query(q); err != nil {
+		return nil, err
+	}
+	return influxdb.NewChunkedResponse(strings.NewReader("")), nil
+}
+
+func (c *explainClient) Query(q influxdb.Query) (*influxdb.Response, error) {
+	if q.Database != c.lastDB || q.RetentionPolicy != c.lastRP {
+		use := fmt.Sprintf(`USE "%s"`, q.Database)
+		if q.RetentionPolicy != "" {
+			use += fmt.Sprintf(`."%s"`, q.RetentionPolicy)
+		}
+		_, _ = fmt.Fprintln(c.w, use)
+		c.lastDB, c.lastRP = q.Database, q.RetentionPolicy
+	}
+	_, _ = fmt.Fprintln(c.w, q.Command)
+	return &influxdb.Response{}, nil
+}
+
+func (c *explainClient) Close() error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	SourceInflux = "influx"
+	SourceHTTP   = "http"
+)
+
+type FeedArgs struct {
+	URL           string
+	Fields        FeedFieldsFlag // field name -> path of its value in the feed's JSON document
+	MeasurementTo string
+	WriteTags     map[string]string
+	Timeout       time.Duration
+}
+
+// FeedPoint polls a weather station's local JSON endpoint (e.g. an Ambient Weather
+// or Ecowitt gateway) and returns a raw sample point containing each mapped field.
+// Fields missing from the feed, or whose values aren't numeric, are omitted.
+func FeedPoint(args FeedArgs) (*influxdb.Point, error) {
+	client := &http.Client{Timeout: args.Timeout}
+	resp, err := client.Get(args.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch feed: %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse feed JSON: %w", err)
+	}
+
+	fields := make(map[string]interface{})
+	for name, path := range args.Fields {
+		v, err := feedValue(doc, path)
+		if err != nil {
+			log.Printf("feed field %s: %s", name, err)
+			continue
+		}
+		fields[name] = v
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("feed contained none of the mapped fields")
+	}
+
+	point, err := influxdb.NewPoint(args.MeasurementTo, args.WriteTags, fields, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create InfluxDB point: %w", err)
+	}
+	return point, nil
+}
+
+// feedValue extracts the numeric value at path from a decoded JSON document.
+// A path is a dot-separated list of segments; each segment is an object key, an
+// array index, or a key=value selector which picks the first object in an array
+// having that key set to that value. For example, "common_list.id=0x0A.val"
+// selects the "val" of the entry with id "0x0A" in Ecowitt's common_list.
+//
+// String values are parsed leniently, since gateways often include units
+// (e.g. "12.3 mph"): the leading number is used.
+func feedValue(doc any, path string) (float64, error) {
+	cur := doc
+	for _, seg := range strings.Split(path, ".") {
+		switch node := cur.(type) {
+		case map[string]any:
+			next, ok := node[seg]
+			if !ok {
+				return 0, fmt.Errorf("key '%s' not found", seg)
+			}
+			cur = next
+		case []any:
+			if k, v, ok := strings.Cut(seg, "="); ok {
+				found := false
+				for _, elem := range node {
+					if obj, isObj := elem.(map[string]any); isObj && fmt.Sprint(obj[k]) == v {
+						cur, found = obj, true
+						break
+					}
+				}
+				if !found {
+					return 0, fmt.Errorf("no element with %s", seg)
+				}
+				continue
+			}
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(node) {
+				return 0, fmt.Errorf("invalid array index '%s'", seg)
+			}
+			cur = node[i]
+		default:
+			return 0, fmt.Errorf("cannot descend into '%s'", seg)
+		}
+	}
+
+	switch v := cur.(type) {
+	case float64:
+		return v, nil
+	case string:
+		numeric := strings.TrimSpace(v)
+		end := 0
+		for end < len(numeric) && strings.ContainsRune("+-.0123456789eE", rune(numeric[end])) {
+			end++
+		}
+		f, err := strconv.ParseFloat(numeric[:end], 64)
+		if err != nil {
+			return 0, fmt.Errorf("value '%s' is not numeric", v)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("value is not numeric")
+	}
+}
+
+// FeedFieldsFlag is a repeatable flag of the form <field>=<json-path>, mapping a
+// field name to the location of its value in a JSON feed.
+type FeedFieldsFlag map[string]string
+
+func (m FeedFieldsFlag) String() string {
+	parts := make([]string, 0, len(m))
+	for k, v := range m {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (m FeedFieldsFlag) Set(value string) error {
+	name, path, ok := strings.Cut(value, "=")
+	name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+	if !ok || name == "" || path == "" {
+		return fmt.Errorf("expected <field>=<json-path>, got '%s'", value)
+	}
+	m[name] = path
+	return nil
+}
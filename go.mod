@@ -1,4 +1,4 @@
-module wx-sta-agg-influx
+module github.com/cdzombak/wx-sta-agg-influx
 
 go 1.23
 
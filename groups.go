@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// seriesGroups returns the -group-by tag values of each source series matching
+// -tags which has data within the widest window any enabled aggregator reads,
+// sorted by tag values. Series lacking any -group-by tag are skipped with a warning.
+func seriesGroups(ctx context.Context, client influxdb.Client, cfg *Config, qTags map[string]string) ([]map[string]string, error) {
+	keys := make([]string, len(cfg.GroupBy))
+	for i, k := range cfg.GroupBy {
+		keys[i] = fmt.Sprintf(`"%s"`, k)
+	}
+	q := fmt.Sprintf("SELECT * FROM %s WHERE time >= now()-%ds %s GROUP BY %s ORDER BY time DESC LIMIT 1",
+		cfg.SourceMeasurement, int64(widestEnabledWindow(cfg).Seconds()), aggregate.PartialWhereClauseForTags(qTags), strings.Join(keys, ", "))
+	log.Printf("[DEBUG] query: %s", q)
+	r, err := aggregate.QueryWithContext(ctx, client, influxdb.Query{Command: q, Database: cfg.SourceDB(), RetentionPolicy: cfg.InfluxRP})
+	if err == nil && r.Error() != nil {
+		err = r.Error()
+	}
+	if err != nil {
+		return nil, &aggregate.QueryError{Query: q, Err: err}
+	}
+	if len(r.Results) == 0 {
+		return nil, nil
+	}
+
+	var retv []map[string]string
+	for _, series := range r.Results[0].Series {
+		group := make(map[string]string, len(cfg.GroupBy))
+		for _, k := range cfg.GroupBy {
+			if v := series.Tags[k]; v != "" {
+				group[k] = v
+			}
+		}
+		if len(group) < len(cfg.GroupBy) {
+			log.Printf("WARNING: skipping source series %v, which lacks a -group-by tag", series.Tags)
+			continue
+		}
+		retv = append(retv, group)
+	}
+	slices.SortFunc(retv, func(a, b map[string]string) int {
+		return strings.Compare(groupString(a), groupString(b))
+	})
+	return retv, nil
+}
+
+// groupString formats a series group's tag values for logs and errors, e.g.
+// "station=home,sensor=roof".
+func groupString(group map[string]string) string {
+	keys := slices.Sorted(maps.Keys(group))
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + group[k]
+	}
+	return strings.Join(parts, ",")
+}
+
+// withGroup returns a copy of tags with the given series group's tag values added.
+func withGroup(tags, group map[string]string) map[string]string {
+	retv := maps.Clone(tags)
+	if retv == nil {
+		retv = make(map[string]string, len(group))
+	}
+	maps.Copy(retv, group)
+	return retv
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+type InfluxClientOptions struct {
+	Addr     string
+	Username string
+	Password string
+
+	// Proxy is the URL of an HTTP proxy to use for all InfluxDB requests.
+	// If empty, the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables are honored.
+	Proxy string
+
+	// UserAgent identifies this program in InfluxDB's request logs.
+	UserAgent string
+
+	// Compress enables gzip compression of write request bodies. If the server
+	// rejects a compressed write, the client falls back to uncompressed writes.
+	Compress bool
+}
+
+// NewInfluxClient creates an InfluxDB client configured from the given options and
+// the INFLUX_TLS_* environment variables. It is an aggregate.ContextClient, so a
+// query in flight is abandoned once its context is done.
+func NewInfluxClient(opts InfluxClientOptions) (influxdb.Client, error) {
+	tlsConfig, err := influxTLSConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := opts.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	httpConfig := influxdb.HTTPConfig{
+		Addr:      opts.Addr,
+		Username:  opts.Username,
+		Password:  opts.Password,
+		Timeout:   influxWriteTimeout,
+		TLSConfig: tlsConfig,
+		Proxy:     proxy,
+		UserAgent: opts.UserAgent,
+	}
+	plain, err := influxdb.NewHTTPClient(httpConfig)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Compress {
+		return newContextClient(plain, httpConfig)
+	}
+
+	compressedConfig := httpConfig
+	compressedConfig.WriteEncoding = influxdb.GzipEncoding
+	compressed, err := influxdb.NewHTTPClient(compressedConfig)
+	if err != nil {
+		return nil, err
+	}
+	return newContextClient(&compressingClient{Client: compressed, plain: plain}, httpConfig)
+}
+
+// compressingClient writes gzip-compressed batches, falling back to its plain client
+// for this and all later writes if the server (or a proxy in front of it) rejects
+// compressed bodies. All other requests go to the compressed client, which only
+// compresses writes.
+type compressingClient struct {
+	influxdb.Client
+	plain    influxdb.Client
+	fallback bool
+}
+
+func (c *compressingClient) Write(bp influxdb.BatchPoints) error {
+	if c.fallback {
+		return c.plain.Write(bp)
+	}
+	err := c.Client.Write(bp)
+	if err != nil && isCompressionRejected(err) {
+		log.Printf("WARNING: InfluxDB rejected a compressed write (%s); writing uncompressed", err)
+		c.fallback = true
+		return c.plain.Write(bp)
+	}
+	return err
+}
+
+func (c *compressingClient) Close() error {
+	return errors.Join(c.Client.Close(), c.plain.Close())
+}
+
+// timedClient records the time spent on each query and write in a run's summary.
+type timedClient struct {
+	influxdb.Client
+	summary *aggregate.RunSummary
+}
+
+func (c timedClient) Query(q influxdb.Query) (*influxdb.Response, error) {
+	start := time.Now()
+	defer func() { c.summary.AddQueryTime(time.Since(start)) }()
+	return c.Client.Query(q)
+}
+
+// QueryAsChunk records only the time until the response starts, since its chunks
+// are read (and parsed) as they stream in.
+func (c timedClient) QueryAsChunk(q influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	start := time.Now()
+	defer func() { c.summary.AddQueryTime(time.Since(start)) }()
+	return c.Client.QueryAsChunk(q)
+}
+
+func (c timedClient) QueryContext(ctx context.Context, q influxdb.Query) (*influxdb.Response, error) {
+	start := time.Now()
+	defer func() { c.summary.AddQueryTime(time.Since(start)) }()
+	return aggregate.QueryWithContext(ctx, c.Client, q)
+}
+
+func (c timedClient) QueryAsChunkContext(ctx context.Context, q influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	start := time.Now()
+	defer func() { c.summary.AddQueryTime(time.Since(start)) }()
+	return aggregate.QueryAsChunkWithContext(ctx, c.Client, q)
+}
+
+func (c timedClient) Write(bp influxdb.BatchPoints) error {
+	start := time.Now()
+	defer func() { c.summary.AddWriteTime(time.Since(start)) }()
+	return c.Client.Write(bp)
+}
+
+// retryingClient retries each query which fails transiently, up to
+// influxQueryRetries attempts. Retrying a single query, rather than the aggregation
+// which sent it, keeps the aggregation from recording its statistics once per
+// attempt. An error reported in a query's response is retried like a failed request.
+type retryingClient struct {
+	influxdb.Client
+}
+
+func (c retryingClient) Query(q influxdb.Query) (*influxdb.Response, error) {
+	return c.QueryContext(context.Background(), q)
+}
+
+func (c retryingClient) QueryContext(ctx context.Context, q influxdb.Query) (*influxdb.Response, error) {
+	var r *influxdb.Response
+	var err error
+	_ = retry.Do(
+		func() error {
+			r, err = aggregate.QueryWithContext(ctx, c.Client, q)
+			if err == nil && r.Error() != nil {
+				return r.Error()
+			}
+			return err
+		},
+		retry.Context(ctx),
+		retry.Attempts(influxQueryRetries),
+		retry.RetryIf(func(err error) bool { return isTransientQueryError(ctx, err) }),
+		retry.LastErrorOnly(true),
+	)
+	return r, err
+}
+
+func (c retryingClient) QueryAsChunk(q influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	return c.QueryAsChunkContext(context.Background(), q)
+}
+
+// QueryAsChunkContext retries only starting the query; once its response is
+// streaming, a failure is the caller's.
+func (c retryingClient) QueryAsChunkContext(ctx context.Context, q influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	var r *influxdb.ChunkedResponse
+	err := retry.Do(
+		func() error {
+			var err error
+			r, err = aggregate.QueryAsChunkWithContext(ctx, c.Client, q)
+			return err
+		},
+		retry.Context(ctx),
+		retry.Attempts(influxQueryRetries),
+		retry.RetryIf(func(err error) bool { return isTransientQueryError(ctx, err) }),
+		retry.LastErrorOnly(true),
+	)
+	return r, err
+}
+
+// isCompressionRejected reports whether a write error indicates the server didn't
+// decompress the request body: either it refused the encoding outright, or it tried
+// to parse the gzip stream (which begins with the byte 0x1f) as line protocol and
+// echoed it back in its error, raw or JSON-escaped.
+func isCompressionRejected(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unsupported media type") ||
+		strings.Contains(msg, "content-encoding") ||
+		strings.Contains(msg, "\x1f") ||
+		strings.Contains(msg, `\u001f`)
+}
+
+func (opts InfluxClientOptions) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	// the v1 client uses no proxy at all unless one is configured explicitly:
+	if opts.Proxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxyURL, err := url.Parse(opts.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL '%s': must include scheme and host", opts.Proxy)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// InfluxServerTime returns the InfluxDB server's current time, read from the Date
+// header of its /ping response. The v1 client doesn't expose response headers, so
+// this makes its own request using the same TLS and proxy settings.
+//
+// The Date header has one-second resolution; the result is adjusted by half the
+// request's round-trip time.
+func InfluxServerTime(opts InfluxClientOptions) (time.Time, error) {
+	tlsConfig, err := influxTLSConfigFromEnv()
+	if err != nil {
+		return time.Time{}, err
+	}
+	proxy, err := opts.proxyFunc()
+	if err != nil {
+		return time.Time{}, err
+	}
+	client := &http.Client{
+		Timeout:   influxReadTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: proxy},
+	}
+
+	u, err := url.Parse(opts.Addr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid InfluxDB URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ping"
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if opts.Username != "" {
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	rtt := time.Since(start)
+
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, errors.New("InfluxDB /ping response has no Date header")
+	}
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid Date header '%s': %w", date, err)
+	}
+	return t.Add(rtt / 2), nil
+}
+
+// influxTLSConfigFromEnv builds a TLS config from the INFLUX_TLS_* environment
+// variables. It returns nil if none are set, in which case the client's defaults apply.
+func influxTLSConfigFromEnv() (*tls.Config, error) {
+	skipVerifyStr := os.Getenv("INFLUX_TLS_SKIP_VERIFY")
+	caFile := os.Getenv("INFLUX_TLS_CA_FILE")
+	certFile := os.Getenv("INFLUX_TLS_CERT_FILE")
+	keyFile := os.Getenv("INFLUX_TLS_KEY_FILE")
+
+	if skipVerifyStr == "" && caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	retv := &tls.Config{}
+
+	if skipVerifyStr != "" {
+		skipVerify, err := strconv.ParseBool(skipVerifyStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INFLUX_TLS_SKIP_VERIFY '%s': %w", skipVerifyStr, err)
+		}
+		retv.InsecureSkipVerify = skipVerify
+	}
+
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read INFLUX_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no valid PEM certificates found in INFLUX_TLS_CA_FILE '%s'", caFile)
+		}
+		retv.RootCAs = pool
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("INFLUX_TLS_CERT_FILE and INFLUX_TLS_KEY_FILE must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		retv.Certificates = []tls.Certificate{cert}
+	}
+
+	return retv, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	"github.com/influxdata/influxdb1-client/models"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// flakyClient fails the first attempt of each query containing failOn with err,
+// and answers rain sample queries with rows.
+type flakyClient struct {
+	influxdb.Client
+	failOn string
+	err    error
+	rows   [][]any
+	failed map[string]bool
+	sent   int
+}
+
+func (c *flakyClient) Query(q influxdb.Query) (*influxdb.Response, error) {
+	c.sent++
+	if strings.Contains(q.Command, c.failOn) && !c.failed[q.Command] {
+		c.failed[q.Command] = true
+		return nil, c.err
+	}
+	result := influxdb.Result{}
+	if strings.HasPrefix(q.Command, "SELECT time, rain FROM") {
+		result.Series = []models.Row{{Name: "wx", Columns: []string{"time", "rain"}, Values: c.rows}}
+	}
+	return &influxdb.Response{Results: []influxdb.Result{result}}, nil
+}
+
+func TestRetryingClientRetriesQueryNotAggregation(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var rows [][]any
+	for i := range 5 {
+		rows = append(rows, []any{now.Add(time.Duration(i-4) * time.Minute).Format(time.RFC3339), json.Number(strings.Repeat("1", i+1))})
+	}
+	client := &flakyClient{failOn: "rain_event", err: errors.New("dial tcp: connection refused"), rows: rows, failed: map[string]bool{}}
+	summary := aggregate.NewRunSummary(now)
+	_, err := aggregate.RainAgg(context.Background(), aggregate.RainAggArgs{
+		CommonArgs: aggregate.CommonArgs{
+			MeasurementFrom: "wx",
+			MeasurementTo:   "wx_agg",
+			MinSamples:      2,
+			Summary:         summary,
+			Now:             func() time.Time { return now },
+		},
+		Store:     aggregate.Store{Influx: retryingClient{client}},
+		RainField: "rain",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.failed) != 1 {
+		t.Fatalf("failed %d queries, want 1 (the event lookup)", len(client.failed))
+	}
+	if summary.SamplesRead != len(rows) {
+		t.Errorf("SamplesRead = %d, want %d", summary.SamplesRead, len(rows))
+	}
+}
+
+func TestRetryingClientPermanentError(t *testing.T) {
+	client := &flakyClient{failOn: "SELECT", err: errors.New("database not found: wx"), failed: map[string]bool{}}
+	_, err := retryingClient{client}.Query(influxdb.Query{Command: "SELECT 1"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if client.sent != 1 {
+		t.Errorf("sent %d queries, want 1", client.sent)
+	}
+}
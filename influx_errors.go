@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ec "github.com/cdzombak/exitcode_go"
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+)
+
+// WriteError is returned when writing points to InfluxDB fails.
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string { return fmt.Sprintf("failed to write to InfluxDB: %s", e.Err) }
+func (e *WriteError) Unwrap() error { return e.Err }
+
+// PartialWriteError is returned when InfluxDB accepted some points of a batch but
+// rejected others. Err is the server's reason for the rejection; InfluxDB reports
+// only the first rejected point's reason, even if several were dropped.
+type PartialWriteError struct {
+	Total   int // points in the batch
+	Dropped int // points rejected, or 0 if the server didn't say
+	Err     error
+}
+
+func (e *PartialWriteError) Error() string {
+	if e.Dropped == 0 {
+		return fmt.Sprintf("partial write: some of %d points were rejected: %s", e.Total, e.Err)
+	}
+	return fmt.Sprintf("partial write: %d of %d points were rejected: %s", e.Dropped, e.Total, e.Err)
+}
+func (e *PartialWriteError) Unwrap() error { return e.Err }
+
+// Written returns the number of points InfluxDB accepted, if known.
+func (e *PartialWriteError) Written() int {
+	return e.Total - e.Dropped
+}
+
+var partialWriteRegexp = regexp.MustCompile(`partial write: (.*?)(?: dropped=(\d+))?"?\}?\s*$`)
+
+// parsePartialWrite returns a *PartialWriteError if the given write error reports
+// a partial write of a batch of total points, or nil otherwise.
+func parsePartialWrite(err error, total int) *PartialWriteError {
+	if err == nil {
+		return nil
+	}
+	m := partialWriteRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return nil
+	}
+	retv := &PartialWriteError{Total: total, Err: errors.New(m[1])}
+	if conflictErr := parseFieldTypeConflict(err); conflictErr != nil {
+		retv.Err = conflictErr
+	}
+	if m[2] != "" {
+		retv.Dropped, _ = strconv.Atoi(m[2])
+	}
+	return retv
+}
+
+// isTransient reports whether the given error may succeed on retry. Nothing is
+// retried once ctx, the context of the run which failed, is done; a request which
+// timed out on its own, within the HTTP client's timeout, may be.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var qe *aggregate.QueryError
+	var we *WriteError
+	return (errors.As(err, &qe) || errors.As(err, &we)) && !isPermanentInfluxError(err)
+}
+
+// isTransientQueryError is isTransient for an error from the query client itself,
+// before the aggregate package wraps it in a *aggregate.QueryError.
+func isTransientQueryError(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !isPermanentInfluxError(err)
+}
+
+// permanentInfluxErrors are fragments of InfluxDB error messages which mean the
+// request was refused (with a 4xx status) and would be refused again: failed
+// authentication or authorization, a missing database or retention policy, an
+// unparseable query or point, or a point rejected by the schema or a limit.
+var permanentInfluxErrors = []string{
+	"authorization failed",
+	"unable to parse authentication credentials",
+	"user not found",
+	"not authorized",
+	"forbidden",
+	"database not found",
+	"retention policy not found",
+	"error parsing query",
+	"unable to parse",
+	"bad timestamp",
+	"field type conflict",
+	"partial write",
+	"points beyond retention policy",
+	"request entity too large",
+}
+
+// clientErrorStatusRegexp matches the client's error for any other 4xx status,
+// which is permanent too unless it's in transientClientErrorStatuses.
+var clientErrorStatusRegexp = regexp.MustCompile(`received status code (4\d\d)`)
+
+// transientClientErrorStatuses are 4xx statuses which may succeed on retry:
+// 408 Request Timeout and 429 Too Many Requests.
+var transientClientErrorStatuses = []string{"408", "429"}
+
+// isPermanentInfluxError reports whether an InfluxDB query or write error means
+// the request can never succeed as sent. The client doesn't expose the HTTP status
+// code of a failed write, so this matches the server's error message. Anything
+// else, such as a timeout, a refused connection, or a 5xx status, may be transient.
+func isPermanentInfluxError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	if slices.ContainsFunc(permanentInfluxErrors, func(s string) bool {
+		return strings.Contains(msg, s)
+	}) {
+		return true
+	}
+	m := clientErrorStatusRegexp.FindStringSubmatch(msg)
+	return m != nil && !slices.Contains(transientClientErrorStatuses, m[1])
+}
+
+// exitCodeTimeout is the exit code for a run which exceeded -run-timeout; it's the
+// code timeout(1) uses.
+const exitCodeTimeout = 124
+
+// RunTimeoutError is returned for a run abandoned because it exceeded -run-timeout.
+// A single request which times out on its own is reported as the request's failure.
+type RunTimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *RunTimeoutError) Error() string {
+	return fmt.Sprintf("run exceeded -run-timeout of %s: %s", e.Timeout, e.Err)
+}
+func (e *RunTimeoutError) Unwrap() error { return e.Err }
+
+// exitCodeForError maps a failure to the process exit code documented in the README.
+func exitCodeForError(err error) int {
+	var qe *aggregate.QueryError
+	var we *WriteError
+	var se *aggregate.SchemaError
+	var pe *aggregate.ParseError
+	var fe *FieldTypeConflictError
+	var pwe *PartialWriteError
+	var ee *aggregate.EmptyResultError
+	var te *RunTimeoutError
+	switch {
+	case errors.As(err, &te):
+		return exitCodeTimeout
+	case errors.As(err, &qe), errors.As(err, &we):
+		return ec.Unavailable
+	case errors.As(err, &se), errors.As(err, &pe), errors.As(err, &fe), errors.As(err, &pwe):
+		return ec.DataErr
+	case errors.As(err, &ee):
+		return ec.NoInput
+	default:
+		return ec.Failure
+	}
+}
+
+// FieldTypeConflictError describes an InfluxDB write rejected because a field
+// was written with a different type than the one already stored in the measurement.
+type FieldTypeConflictError struct {
+	Field       string
+	Measurement string
+	Actual      string
+	Expected    string
+}
+
+func (e *FieldTypeConflictError) Error() string {
+	return fmt.Sprintf(
+		"field type conflict: field '%s' in measurement '%s' is stored as %s, but this program wrote %s; "+
+			"drop or rename the existing field, or use -field-suffix to write to differently named fields",
+		e.Field, e.Measurement, e.Expected, e.Actual,
+	)
+}
+
+// the client returns the server's JSON error body verbatim, so quotes may be escaped:
+var fieldTypeConflictRegexp = regexp.MustCompile(
+	`field type conflict: input field \\?"([^"\\]+)\\?" on measurement \\?"([^"\\]+)\\?" is type (\w+), already exists as type (\w+)`,
+)
+
+// parseFieldTypeConflict returns a *FieldTypeConflictError if the given write
+// error was caused by a field type conflict, or nil otherwise.
+func parseFieldTypeConflict(err error) *FieldTypeConflictError {
+	if err == nil {
+		return nil
+	}
+	m := fieldTypeConflictRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return nil
+	}
+	return &FieldTypeConflictError{
+		Field:       m[1],
+		Measurement: m[2],
+		Actual:      m[3],
+		Expected:    m[4],
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ec "github.com/cdzombak/exitcode_go"
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestIsPermanentInfluxError(t *testing.T) {
+	tests := []struct {
+		err  string
+		want bool
+	}{
+		{`{"error":"authorization failed"}`, true},
+		{`{"error":"database not found: \"wx\""}`, true},
+		{`{"error":"retention policy not found: short"}`, true},
+		{`error parsing query: found EOF, expected FROM at line 1, char 9`, true},
+		{`{"error":"field type conflict: input field \"temp\" on measurement \"wx\" is type integer, already exists as type float dropped=1"}`, true},
+		{`{"error":"partial write: points beyond retention policy dropped=2"}`, true},
+		{`Request Entity Too Large`, true},
+		{`received status code 400 from server`, true},
+		{`received status code 404 from server`, true},
+		{`received status code 413 from server`, true},
+		{`received status code 408 from server`, false},
+		{`received status code 429 from server`, false},
+		{`received status code 500 from server`, false},
+		{`received status code 502 from downstream server`, false},
+		{`received status code 503 from downstream server, with response body: "busy"`, false},
+		{`Post "http://influx:8086/query": dial tcp 10.0.0.1:8086: connect: connection refused`, false},
+		{`Post "http://influx:8086/write": context deadline exceeded (Client.Timeout exceeded while awaiting headers)`, false},
+		{`unexpected EOF`, false},
+	}
+	for _, tt := range tests {
+		if got := isPermanentInfluxError(errors.New(tt.err)); got != tt.want {
+			t.Errorf("isPermanentInfluxError(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestRequestTimeoutIsTransient checks that a request which outlasts the HTTP
+// client's own timeout is retried, while one abandoned with its run is not.
+func TestRequestTimeoutIsTransient(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	conf := influxdb.HTTPConfig{Addr: srv.URL, Timeout: 50 * time.Millisecond}
+	v1, err := influxdb.NewHTTPClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newContextClient(v1, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	q := influxdb.Query{Command: "SELECT 1", Database: "wx"}
+
+	_, err = retryingClient{client}.QueryContext(context.Background(), q)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want a client timeout satisfying context.DeadlineExceeded", err)
+	}
+	if got := requests.Load(); got != influxQueryRetries {
+		t.Errorf("sent %d requests, want %d: a request timeout should be retried", got, influxQueryRetries)
+	}
+	if !isTransient(context.Background(), &aggregate.QueryError{Err: err}) {
+		t.Error("isTransient(request timeout) = false, want true")
+	}
+	if !isTransient(context.Background(), &WriteError{Err: err}) {
+		t.Error("isTransient(write timeout) = false, want true")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if isTransient(ctx, &aggregate.QueryError{Err: err}) {
+		t.Error("isTransient() = true after the run's context is done, want false")
+	}
+	requests.Store(0)
+	runCtx, cancelRun := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelRun()
+	if _, err := (retryingClient{client}).QueryContext(runCtx, q); err == nil {
+		t.Fatal("query succeeded, want an error")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("sent %d requests after the run's deadline, want 1", got)
+	}
+}
+
+func TestExitCodeForTimeouts(t *testing.T) {
+	requestTimeout := &aggregate.QueryError{Err: context.DeadlineExceeded}
+	if got := exitCodeForError(requestTimeout); got == exitCodeTimeout {
+		t.Errorf("exitCodeForError(request timeout) = %d; only the run's own timeout exits %d", got, exitCodeTimeout)
+	}
+	if got := exitCodeForError(fmt.Errorf("run abandoned: %w", context.DeadlineExceeded)); got == exitCodeTimeout {
+		t.Errorf("exitCodeForError(bare deadline) = %d, want a generic failure", got)
+	}
+	runTimeout := &RunTimeoutError{Timeout: time.Minute, Err: requestTimeout}
+	if got := exitCodeForError(fmt.Errorf("aggregation 'rain' failed: %w", runTimeout)); got != exitCodeTimeout {
+		t.Errorf("exitCodeForError(run timeout) = %d, want %d", got, exitCodeTimeout)
+	}
+	if want := "run exceeded -run-timeout of 1m0s: InfluxDB query failed: context deadline exceeded"; runTimeout.Error() != want {
+		t.Errorf("RunTimeoutError.Error() = %q, want %q", runTimeout.Error(), want)
+	}
+}
+
+func TestExitCodeForError(t *testing.T) {
+	cause := errors.New("cause")
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&aggregate.QueryError{Query: "SELECT 1", Err: cause}, ec.Unavailable},
+		{&WriteError{Err: cause}, ec.Unavailable},
+		{&aggregate.SchemaError{Msg: "expected 1 series, got 2"}, ec.DataErr},
+		{&aggregate.ParseError{What: "temp", Err: cause}, ec.DataErr},
+		{&FieldTypeConflictError{Field: "temp", Measurement: "wx", Actual: "integer", Expected: "float"}, ec.DataErr},
+		{&PartialWriteError{Total: 3, Dropped: 1, Err: cause}, ec.DataErr},
+		{&aggregate.EmptyResultError{Msg: "no numeric data to aggregate"}, ec.NoInput},
+		{&RunTimeoutError{Timeout: time.Minute, Err: cause}, exitCodeTimeout},
+		{&TooManyPointsError{Points: 10, Max: 5}, ec.Failure},
+		{cause, ec.Failure},
+	}
+	for _, tt := range tests {
+		if got := exitCodeForError(tt.err); got != tt.want {
+			t.Errorf("exitCodeForError(%T) = %d, want %d", tt.err, got, tt.want)
+		}
+		// aggregators' errors reach main wrapped:
+		if got := exitCodeForError(fmt.Errorf("aggregation 'numeric' failed: %w", tt.err)); got != tt.want {
+			t.Errorf("exitCodeForError(wrapped %T) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
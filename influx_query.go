@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// contextClient adds QueryContext and QueryAsChunkContext to a v1 client, whose own
+// queries can't be cancelled once they're sent. They make the same requests, and
+// report the same errors, as the v1 client's Query and QueryAsChunk; all other
+// requests go to the wrapped client.
+type contextClient struct {
+	influxdb.Client
+	url        url.URL
+	username   string
+	password   string
+	userAgent  string
+	httpClient *http.Client
+}
+
+func newContextClient(c influxdb.Client, conf influxdb.HTTPConfig) (influxdb.Client, error) {
+	u, err := url.Parse(conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if conf.UserAgent == "" {
+		conf.UserAgent = "InfluxDBClient"
+	}
+	tr := &http.Transport{TLSClientConfig: conf.TLSConfig, Proxy: conf.Proxy}
+	return &contextClient{
+		Client:     c,
+		url:        *u,
+		username:   conf.Username,
+		password:   conf.Password,
+		userAgent:  conf.UserAgent,
+		httpClient: &http.Client{Timeout: conf.Timeout, Transport: tr},
+	}, nil
+}
+
+func (c *contextClient) QueryContext(ctx context.Context, q influxdb.Query) (*influxdb.Response, error) {
+	req, err := c.queryRequest(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if err := checkQueryResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var response influxdb.Response
+	if q.Chunked {
+		cr := influxdb.NewChunkedResponse(resp.Body)
+		for {
+			r, err := cr.NextResponse()
+			if errors.Is(err, io.EOF) || (err == nil && r == nil) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			response.Results = append(response.Results, r.Results...)
+			if r.Err != "" {
+				response.Err = r.Err
+				break
+			}
+		}
+	} else {
+		dec := json.NewDecoder(resp.Body)
+		dec.UseNumber()
+		decErr := dec.Decode(&response)
+		if decErr != nil && decErr.Error() == "EOF" && resp.StatusCode != http.StatusOK {
+			decErr = nil
+		}
+		if decErr != nil {
+			return nil, fmt.Errorf("unable to decode json: received status code %d err: %s", resp.StatusCode, decErr)
+		}
+	}
+	if resp.StatusCode != http.StatusOK && response.Error() == nil {
+		return &response, fmt.Errorf("received status code %d from server", resp.StatusCode)
+	}
+	return &response, nil
+}
+
+// QueryAsChunkContext returns a streamed response; reading its chunks fails once
+// ctx is done.
+func (c *contextClient) QueryAsChunkContext(ctx context.Context, q influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	q.Chunked = true
+	req, err := c.queryRequest(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkQueryResponse(resp); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return influxdb.NewChunkedResponse(resp.Body), nil
+}
+
+func (c *contextClient) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return c.Client.Close()
+}
+
+// queryRequest builds the same /query request as the v1 client.
+func (c *contextClient) queryRequest(ctx context.Context, q influxdb.Query) (*http.Request, error) {
+	u := c.url
+	u.Path = path.Join(u.Path, "query")
+
+	jsonParameters, err := json.Marshal(q.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "")
+	req.Header.Set("User-Agent", c.userAgent)
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	params := req.URL.Query()
+	params.Set("q", q.Command)
+	params.Set("db", q.Database)
+	if q.RetentionPolicy != "" {
+		params.Set("rp", q.RetentionPolicy)
+	}
+	params.Set("params", string(jsonParameters))
+	if q.Precision != "" {
+		params.Set("epoch", q.Precision)
+	}
+	if q.Chunked {
+		params.Set("chunked", "true")
+		if q.ChunkSize > 0 {
+			params.Set("chunk_size", strconv.Itoa(q.ChunkSize))
+		}
+	}
+	req.URL.RawQuery = params.Encode()
+	return req, nil
+}
+
+// checkQueryResponse reports a response which didn't come from InfluxDB itself,
+// exactly as the v1 client does, so that isPermanentInfluxError matches its errors.
+func checkQueryResponse(resp *http.Response) error {
+	if resp.Header.Get("X-Influxdb-Version") == "" && resp.StatusCode >= http.StatusInternalServerError {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil || len(body) == 0 {
+			return fmt.Errorf("received status code %d from downstream server", resp.StatusCode)
+		}
+		return fmt.Errorf("received status code %d from downstream server, with response body: %q", resp.StatusCode, body)
+	}
+	if cType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); cType != "application/json" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if err != nil || len(body) == 0 {
+			return fmt.Errorf("expected json response, got empty body, with status: %v", resp.StatusCode)
+		}
+		return fmt.Errorf("expected json response, got %q, with status: %v and response body: %q", cType, resp.StatusCode, body)
+	}
+	return nil
+}
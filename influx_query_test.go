@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// TestQueryContextCancelsInFlight checks that cancelling a query's context abandons
+// its HTTP request, rather than waiting out the client's timeout.
+func TestQueryContextCancelsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client, err := NewInfluxClient(InfluxClientOptions{Addr: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, ok := client.(aggregate.ContextClient); !ok {
+		t.Fatalf("NewInfluxClient returned a %T, which isn't an aggregate.ContextClient", client)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = aggregate.QueryWithContext(ctx, timedClient{client, aggregate.NewRunSummary(time.Now())}, influxdb.Query{Command: "SELECT 1", Database: "wx"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed >= influxWriteTimeout {
+		t.Errorf("query returned after %s; it wasn't cancelled", elapsed)
+	}
+}
+
+// TestQueryContextRequest checks that QueryContext sends the same request, and
+// decodes the same response, as the v1 client's Query.
+func TestQueryContextRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		user, pass, _ := r.BasicAuth()
+		if r.Method != http.MethodPost || r.URL.Path != "/query" ||
+			q.Get("q") != "SELECT t FROM wx" || q.Get("db") != "wx" || q.Get("rp") != "autogen" || q.Get("epoch") != "s" ||
+			user != "u" || pass != "p" || r.UserAgent() != "test-agent" {
+			t.Errorf("unexpected request: %s %s (user %q, user agent %q)", r.Method, r.URL, user, r.UserAgent())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Influxdb-Version", "1.8")
+		_, _ = w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"name":"wx","columns":["time","t"],"values":[[1700000000,1.5]]}]}]}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewInfluxClient(InfluxClientOptions{Addr: srv.URL, Username: "u", Password: "p", UserAgent: "test-agent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	q := influxdb.Query{Command: "SELECT t FROM wx", Database: "wx", RetentionPolicy: "autogen", Precision: "s"}
+	want, err := client.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := aggregate.QueryWithContext(context.Background(), client, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Results) != 1 || len(got.Results[0].Series) != 1 {
+		t.Fatalf("unexpected response: %+v", got)
+	}
+	if g, w := got.Results[0].Series[0].Values[0][1], want.Results[0].Series[0].Values[0][1]; g != w {
+		t.Errorf("value = %v (%T), want %v (%T)", g, g, w, w)
+	}
+}
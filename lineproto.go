@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	DryRunFormatTable        = "table"
+	DryRunFormatLineProtocol = "line-protocol"
+)
+
+// writeLineProtocol writes each point to w as a line of InfluxDB line protocol,
+// which `influx write` (v1 or v2) accepts at its default nanosecond precision.
+// Lines are serialized by the client, exactly as they'd be written, and so are
+// deterministic: tags and fields are sorted by key, empty tags are omitted, and
+// floats are written in their shortest exact decimal form, never in exponent
+// notation.
+func writeLineProtocol(w io.Writer, points []*influxdb.Point) error {
+	for _, p := range points {
+		if _, err := io.WriteString(w, p.String()+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files")
+
+func TestWriteLineProtocolGolden(t *testing.T) {
+	ts := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	specs := []struct {
+		name   string
+		tags   map[string]string
+		fields map[string]any
+	}{
+		{"weather_station_agg", map[string]string{"station": "backyard", "location": "", "area": "north"}, map[string]any{
+			"temp_mean_1h": 21.25, "temp_max_1h": 24.0, "rain_1h": 0.0, "samples_1h": int64(60), "battery_low_1h": false,
+		}},
+		{"weather station,agg", map[string]string{"site name": "a=b,c"}, map[string]any{
+			"big": 1e21, "tiny": 1.5e-7, "neg": -0.1, "wind_dir_str_1h": `N"E\`, "max_int": int64(9223372036854775807),
+		}},
+	}
+	var points []*influxdb.Point
+	for _, s := range specs {
+		p, err := influxdb.NewPoint(s.name, s.tags, s.fields, ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		points = append(points, p)
+	}
+
+	var buf bytes.Buffer
+	if err := writeLineProtocol(&buf, points); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "line_protocol.golden")
+	if *updateGolden {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(want) {
+		t.Errorf("writeLineProtocol() =\n%s\nwant\n%s", got, want)
+	}
+}
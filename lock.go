@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// ErrLockHeld is returned by AcquireLock when another process holds the lock.
+var ErrLockHeld = errors.New("another instance holds the lock")
+
+// Lock is an exclusive advisory lock on a file, held for the life of a run.
+type Lock struct {
+	f *os.File
+}
+
+// DefaultLockPath returns a lock file path in the system temp directory, keyed by
+// measurement and tags so that runs for different stations don't block each other.
+func DefaultLockPath(measurement string, tags map[string]string) string {
+	tagParts := make([]string, 0, len(tags))
+	for k, v := range tags {
+		tagParts = append(tagParts, k+"="+v)
+	}
+	sort.Strings(tagParts)
+	sum := sha256.Sum256([]byte(measurement + "," + strings.Join(tagParts, ",")))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("wx-sta-agg-influx-%s.lock", hex.EncodeToString(sum[:8])))
+}
+
+// AcquireLock takes an exclusive flock on the file at path, creating it if needed.
+// It does not wait; if another process holds the lock, it returns ErrLockHeld.
+//
+// The OS releases the lock when the process exits for any reason, including
+// os.Exit and fatal signals, so a crashed run never leaves a stale lock behind.
+func AcquireLock(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lockfile '%s': %w", path, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrLockHeld, path)
+		}
+		return nil, fmt.Errorf("failed to lock '%s': %w", path, err)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release unlocks and closes the lock file. The file itself is left in place;
+// removing it could let two processes lock different inodes at the same path.
+func (l *Lock) Release() {
+	if l == nil || l.f == nil {
+		return
+	}
+	_ = syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	_ = l.f.Close()
+	l.f = nil
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"maps"
 	"os"
@@ -13,105 +15,188 @@ import (
 
 	"github.com/avast/retry-go"
 	ec "github.com/cdzombak/exitcode_go"
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
 	influxdb "github.com/influxdata/influxdb1-client/v2"
-	"github.com/joho/godotenv"
 )
 
 const (
 	influxReadTimeout  = 30 * time.Second
 	influxWriteTimeout = 5 * time.Second
 	influxWriteRetries = 2
+	influxQueryRetries = 2
+
+	clockSkewWarnThreshold = 10 * time.Second
 
 	ProductName = "wx-station-aggregator-influx"
 )
 
 var Version = "<dev>"
 
+// errLog logs errors, and output explicitly requested by a flag such as -summary.
+// Unlike the standard logger, it isn't silenced by -quiet.
+var errLog = log.New(RedactingWriter{W: os.Stderr}, "", log.LstdFlags)
+
 func main() {
-	measurementName := flag.String("measurement", "weather_station", "Name of the measurement to read")
-	tagsIn := flag.String("tags", "", "Comma-separated list of tag=value pairs to filter by and include in result measurements")
-	windDirectionField := flag.String("wind-dir-field", "", "Name of the field to use for wind direction (in degrees); if not set, wind direction will not be aggregated")
-	windSpeedField := flag.String("wind-speed-field", "", "Name of the field to use for wind speed; required iff wind-dir-field is given")
-	rainGaugeField := flag.String("rain-field", "", "Name of the field to use for rain gauge (in mm); if not set, rain gauge will not be aggregated")
-	envFileName := flag.String("env", "", "Path to .env file to load environment variables from")
-	dryRun := flag.Bool("dry-run", false, "Print points that would be written instead of writing to InfluxDB")
-	printVersion := flag.Bool("version", false, "Print version and exit")
-	flag.Parse()
-
-	if *printVersion {
+	// nothing logged, including fatal errors, may leak a secret:
+	log.SetOutput(RedactingWriter{W: os.Stderr})
+
+	cfg, err := ParseConfig()
+	if err != nil {
+		errLog.Fatalln(err)
+	}
+	if cfg.Quiet {
+		log.SetOutput(io.Discard)
+	}
+
+	if cfg.PrintVersion {
 		fmt.Printf("%s version %s\n", ProductName, Version)
 		os.Exit(ec.Success)
 	}
 
-	if *envFileName != "" {
-		if err := godotenv.Load(*envFileName); err != nil {
-			log.Fatalf("Failed to load '%s': %v", *envFileName, err)
+	if cfg.PrintConfigEnv {
+		cfg.PrintEnv(os.Stdout)
+		os.Exit(ec.Success)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		errLog.Fatalf("Invalid configuration: %s", err)
+	}
+	if cfg.ValidateConfig {
+		cfg.Print(os.Stdout)
+		os.Exit(ec.Success)
+	}
+	if cfg.ExtremeTimeAsTag {
+		log.Printf("WARNING: -extreme-time-as-tag creates a new series for every distinct extreme time, which grows the measurement's series cardinality without bound")
+	}
+
+	if !cfg.DryRun && !cfg.Explain && !cfg.SelfTest {
+		lock, err := AcquireLock(cfg.Lockfile)
+		if errors.Is(err, ErrLockHeld) {
+			errLog.Printf("refusing to run: %s", err)
+			os.Exit(ec.TempFail)
+		} else if err != nil {
+			errLog.Fatalln(err)
 		}
+		defer lock.Release()
 	}
 
-	influxClient, err := influxdb.NewHTTPClient(influxdb.HTTPConfig{
-		Addr:    os.Getenv("INFLUX_SERVER"),
-		Timeout: influxWriteTimeout,
-	})
-	if err != nil {
-		log.Fatalf("Failed to create InfluxDB client: %s", err)
+	var influxClient influxdb.Client
+	if cfg.Explain {
+		influxClient = NewExplainClient(os.Stdout)
+	} else {
+		username, password := cfg.influxCredentials()
+		influxClient, err = NewInfluxClient(InfluxClientOptions{
+			Addr:      cfg.InfluxServer,
+			Username:  username,
+			Password:  password,
+			Proxy:     cfg.Proxy,
+			UserAgent: cfg.UserAgent,
+			Compress:  cfg.Compress,
+		})
+		if err != nil {
+			errLog.Fatalf("Failed to create InfluxDB client: %s", err)
+		}
 	}
-	if err := influxHealthcheck(influxClient); err != nil {
-		log.Fatalf("InfluxDB ping failed: %s", err)
+	if !cfg.SkipHealthcheck && !cfg.Explain {
+		if err := influxHealthcheck(influxClient); err != nil {
+			errLog.Fatalf("InfluxDB ping failed: %s", err)
+		}
 	}
 	defer influxClient.Close()
 
-	qTags, err := ParseTags(*tagsIn)
-	if err != nil {
-		log.Fatalf("Failed to parse tags: %s", err)
+	var clockSkew time.Duration
+	if !cfg.SkipHealthcheck && !cfg.Explain {
+		clockSkew = checkClockSkew(cfg)
+	}
+	if !cfg.Explain {
+		if err := resolveRetentionPolicy(influxClient, cfg); err != nil {
+			errLog.Println(err)
+			os.Exit(exitCodeForError(err))
+		}
 	}
 
-	wTags := map[string]string{
-		"aggregator": fmt.Sprintf("%s/%s", ProductName, Version),
+	nowFn := time.Now
+	if cfg.UseServerTime && clockSkew != 0 {
+		nowFn = func() time.Time { return time.Now().Add(clockSkew) }
 	}
-	maps.Copy(wTags, qTags)
 
-	if *windDirectionField != "" && *windSpeedField == "" {
-		log.Fatalln("wind-speed-field is required when wind-dir-field is set")
+	qTags := cfg.Tags
+	wTags := make(map[string]string)
+	if cfg.AggregatorTag != "" {
+		wTags["aggregator"] = cfg.AggregatorTag
 	}
+	maps.Copy(wTags, qTags)
 
-	var points []*influxdb.Point
+	if cfg.SelfTest {
+		if err := runSelfTest(influxClient, cfg, wTags); err != nil {
+			errLog.Printf("self-test failed: %s", err)
+			os.Exit(exitCodeForError(err))
+		}
+		log.Printf("self-test passed")
+		return
+	}
 
-	if *windDirectionField != "" {
-		wdPoints, err := WindDirectionAgg(WindDirectionAggArgs{
-			MeasurementFrom:    *measurementName,
-			MeasurementTo:      *measurementName + "_agg",
-			QueryTags:          qTags,
-			WriteTags:          wTags,
-			WindDirectionField: *windDirectionField,
-			WindSpeedField:     *windSpeedField,
+	r := &runner{
+		cfg:    cfg,
+		client: influxClient,
+		store: aggregate.Store{
 			Influx:             influxClient,
-			InfluxDB:           os.Getenv("INFLUX_DB"),
-			InfluxRP:           os.Getenv("INFLUX_RP"),
+			InfluxDB:           cfg.DestDB(),
+			SourceDB:           cfg.SourceDB(),
+			InfluxRP:           cfg.InfluxRP,
 			InfluxQueryTimeout: influxReadTimeout,
-		})
-		if err != nil {
-			log.Fatalf("Wind direction aggregation failed: %s", err)
+			TimeColumn:         cfg.TimeColumn,
+			ArchiveRP:          cfg.InfluxRPArchive,
+			SourceQuery:        cfg.SourceQuery,
+			MultiSeries:        cfg.MultiSeries,
+			ChunkSize:          cfg.SourceChunkSize(),
+			TolerantParse:      cfg.TolerantParse,
+			ReadEpoch:          cfg.ReadEpoch,
+		},
+		qTags:        qTags,
+		wTags:        wTags,
+		sampleFilter: cfg.SampleFilter(),
+		now:          nowFn,
+	}
+	if cfg.DiagDir != "" && !cfg.Explain {
+		r.diag = &diagRecorder{Client: influxClient}
+	}
+
+	if cfg.DaemonInterval > 0 {
+		if err := runDaemon(r); err != nil {
+			os.Exit(exitCodeForError(err))
 		}
-		points = append(points, wdPoints...)
+		return
 	}
 
-	if *rainGaugeField != "" {
-		rainPoints, err := RainAgg(RainAggArgs{
-			MeasurementFrom:    *measurementName,
-			MeasurementTo:      *measurementName + "_agg",
-			QueryTags:          qTags,
-			WriteTags:          wTags,
-			RainField:          *rainGaugeField,
-			Influx:             influxClient,
-			InfluxDB:           os.Getenv("INFLUX_DB"),
-			InfluxRP:           os.Getenv("INFLUX_RP"),
-			InfluxQueryTimeout: influxReadTimeout,
-		})
-		if err != nil {
-			log.Fatalf("Rain gauge aggregation failed: %s", err)
+	ctx, cancel := runContext(cfg)
+	defer cancel()
+	summary := aggregate.NewRunSummary(time.Now())
+	if cfg.ShowSummary {
+		defer func() { errLog.Printf("summary: %s", summary) }()
+	}
+	defer logRunTimings(summary)
+	defer r.emitRunMetadata(summary)
+	fail := func(err error) {
+		// only the run's own deadline is a timeout; a request may time out by itself:
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = &RunTimeoutError{Timeout: cfg.RunTimeout, Err: err}
 		}
-		points = append(points, rainPoints...)
+		errLog.Println(err)
+		summary.AddError()
+		logRunTimings(summary)
+		r.dumpDiagnostics(summary, err)
+		r.emitRunMetadata(summary)
+		os.Exit(exitCodeForError(err))
+	}
+
+	points, err := r.computeAll(ctx, summary)
+	if err != nil {
+		fail(err)
+	}
+
+	if cfg.Explain {
+		return
 	}
 
 	if len(points) == 0 {
@@ -119,29 +204,102 @@ func main() {
 		return
 	}
 
-	if *dryRun {
-		printPoints(points)
+	if cfg.DryRun {
+		printPoints(cfg, points)
 		return
 	}
 
+	if err := ctx.Err(); err != nil {
+		fail(fmt.Errorf("run abandoned before writing: %w", err))
+	}
+	if err := writePoints(timedClient{influxClient, summary}, cfg, points); err != nil {
+		var partialErr *PartialWriteError
+		if errors.As(err, &partialErr) && partialErr.Dropped > 0 {
+			summary.PointsWritten = partialErr.Written()
+		}
+		fail(fmt.Errorf("write failed: %w", err))
+	}
+	summary.PointsWritten = len(points)
+}
+
+// DefaultAggregatorTag is the default value of the aggregator tag on output points:
+// this program's name and version.
+func DefaultAggregatorTag() string {
+	return fmt.Sprintf("%s/%s", ProductName, Version)
+}
+
+// DefaultUserAgent is the default User-Agent header sent with InfluxDB requests:
+// this program's name and version, in the product/version form HTTP expects.
+func DefaultUserAgent() string {
+	return fmt.Sprintf("%s/%s", ProductName, Version)
+}
+
+// writePoints writes the given points to InfluxDB, retrying transient failures.
+func writePoints(client influxdb.Client, cfg *Config, points []*influxdb.Point) error {
+	return writePointsTo(client, cfg, cfg.DestDB(), points)
+}
+
+// writePointsTo is writePoints, writing to the given database.
+func writePointsTo(client influxdb.Client, cfg *Config, database string, points []*influxdb.Point) error {
 	bp, err := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{
-		Database:        os.Getenv("INFLUX_DB"),
-		RetentionPolicy: os.Getenv("INFLUX_RP"),
+		Database:         database,
+		RetentionPolicy:  cfg.InfluxRP,
+		WriteConsistency: cfg.WriteConsistency,
 	})
 	if err != nil {
-		log.Fatalf("failed to create InfluxDB batch: %s", err)
+		return fmt.Errorf("failed to create InfluxDB batch: %w", err)
 	}
 
 	bp.AddPoints(points)
 
 	if err := retry.Do(
 		func() error {
-			return influxClient.Write(bp)
+			return client.Write(bp)
 		},
 		retry.Attempts(influxWriteRetries),
+		retry.RetryIf(func(err error) bool {
+			// e.g. a field type conflict, or failed authentication, will never succeed on retry:
+			return !isPermanentInfluxError(err)
+		}),
+		retry.LastErrorOnly(true),
 	); err != nil {
-		log.Printf("failed to write to Influx: %s", err.Error())
+		if partialErr := parsePartialWrite(err, len(points)); partialErr != nil {
+			return partialErr
+		}
+		if conflictErr := parseFieldTypeConflict(err); conflictErr != nil {
+			return conflictErr
+		}
+		errLog.Printf("failed to write batch of %d points; first point: %s", len(points), points[0].String())
+		return &WriteError{Err: err}
 	}
+	return nil
+}
+
+// checkClockSkew compares the local clock to the InfluxDB server's, logging a warning
+// if they differ by more than clockSkewWarnThreshold. It returns the server's time
+// minus the local time, or 0 if the server's time can't be determined.
+func checkClockSkew(cfg *Config) time.Duration {
+	username, password := cfg.influxCredentials()
+	serverTime, err := InfluxServerTime(InfluxClientOptions{
+		Addr:      cfg.InfluxServer,
+		Username:  username,
+		Password:  password,
+		Proxy:     cfg.Proxy,
+		UserAgent: cfg.UserAgent,
+	})
+	if err != nil {
+		log.Printf("WARNING: could not read the InfluxDB server's time to check for clock skew: %s", err)
+		return 0
+	}
+	skew := serverTime.Sub(time.Now())
+	if skew.Abs() > clockSkewWarnThreshold {
+		if cfg.UseServerTime {
+			log.Printf("local clock differs from the InfluxDB server's by %s; using server time", skew.Round(time.Second))
+		} else {
+			log.Printf("WARNING: local clock differs from the InfluxDB server's by %s; freshness checks may misbehave (consider -use-server-time)", skew.Round(time.Second))
+		}
+	}
+	return skew
 }
 
 func influxHealthcheck(client influxdb.Client) error {
@@ -149,7 +307,13 @@ func influxHealthcheck(client influxdb.Client) error {
 	return err
 }
 
-func printPoints(points []*influxdb.Point) {
+func printPoints(cfg *Config, points []*influxdb.Point) {
+	if cfg.DryRunFormat == DryRunFormatLineProtocol {
+		if err := writeLineProtocol(os.Stdout, points); err != nil {
+			errLog.Printf("failed to print points: %s", err)
+		}
+		return
+	}
 	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
 	_, _ = fmt.Fprintln(w, "MEASUREMENT\tTIME\tTAGS\tFIELDS")
 	for _, p := range points {
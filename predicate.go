@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	predicateIdentRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	predicateOps         = []string{"<=", ">=", "!=", "<>", "=", "<", ">"} // longest first
+)
+
+// ParsePredicate validates a user-supplied filter predicate and returns it as a
+// partial InfluxQL WHERE clause (" AND (...)"), suitable for appending to the tag filter.
+//
+// To avoid InfluxQL injection, only a restricted syntax is accepted: one or more
+// comparisons joined by AND, each of the form <field> <op> <value>, where <field> is
+// an identifier ([A-Za-z_][A-Za-z0-9_]*), <op> is one of = != <> < <= > >=, and <value>
+// is a number, true/false, or a single-quoted string which contains no quotes or backslashes.
+func ParsePredicate(predicate string) (string, error) {
+	predicate = strings.TrimSpace(predicate)
+	if predicate == "" {
+		return "", nil
+	}
+
+	var clauses []string
+	for _, clause := range splitPredicateAnd(predicate) {
+		rendered, err := parsePredicateClause(strings.TrimSpace(clause))
+		if err != nil {
+			return "", fmt.Errorf("invalid predicate '%s': %w", predicate, err)
+		}
+		clauses = append(clauses, rendered)
+	}
+
+	return " AND (" + strings.Join(clauses, " AND ") + ")", nil
+}
+
+// splitPredicateAnd splits the predicate on the AND keyword (case-insensitive),
+// ignoring any occurrences inside single-quoted strings.
+func splitPredicateAnd(predicate string) []string {
+	var retv []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(predicate); i++ {
+		if predicate[i] == '\'' {
+			inQuote = !inQuote
+			continue
+		}
+		if inQuote || i+5 > len(predicate) {
+			continue
+		}
+		if predicate[i] == ' ' && strings.EqualFold(predicate[i:i+5], " and ") {
+			retv = append(retv, predicate[start:i])
+			start = i + 5
+			i += 4
+		}
+	}
+	return append(retv, predicate[start:])
+}
+
+func parsePredicateClause(clause string) (string, error) {
+	// the operator is the earliest match in the clause, preferring the longest
+	// operator at a given position, so that operators within a quoted value are ignored:
+	opIdx := -1
+	opStr := ""
+	for _, op := range predicateOps {
+		idx := strings.Index(clause, op)
+		if idx >= 0 && (opIdx < 0 || idx < opIdx) {
+			opIdx = idx
+			opStr = op
+		}
+	}
+	if opIdx < 0 {
+		return "", fmt.Errorf("clause '%s' has no comparison operator", clause)
+	}
+
+	field := strings.TrimSpace(clause[:opIdx])
+	value := strings.TrimSpace(clause[opIdx+len(opStr):])
+	if !predicateIdentRegexp.MatchString(field) {
+		return "", fmt.Errorf("invalid field name '%s'", field)
+	}
+	renderedValue, err := parsePredicateValue(value)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`"%s" %s %s`, field, opStr, renderedValue), nil
+}
+
+func parsePredicateValue(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		inner := value[1 : len(value)-1]
+		if strings.ContainsAny(inner, `'\`) {
+			return "", fmt.Errorf("string value %s may not contain quotes or backslashes", value)
+		}
+		return value, nil
+	}
+	if strings.EqualFold(value, "true") || strings.EqualFold(value, "false") {
+		return strings.ToLower(value), nil
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("invalid value '%s'", value)
+}
+
+// FiltersFlag is a repeatable flag of the form <aggregation>:<predicate>.
+type FiltersFlag map[string]string
+
+func (f FiltersFlag) String() string {
+	parts := make([]string, 0, len(f))
+	for k, v := range f {
+		parts = append(parts, k+":"+strings.TrimPrefix(v, " AND "))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func (f FiltersFlag) Set(value string) error {
+	aggName, predicate, ok := strings.Cut(value, ":")
+	if !ok || aggName == "" {
+		return fmt.Errorf("expected <aggregation>:<predicate>, got '%s'", value)
+	}
+	rendered, err := ParsePredicate(predicate)
+	if err != nil {
+		return err
+	}
+	f[aggName] += rendered
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestConnectionErrorRedacted forces a failed InfluxDB request whose error echoes
+// the credentials sent, and checks that they don't survive logging it.
+func TestConnectionErrorRedacted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = fmt.Fprintf(w, `{"error":"authorization failed for %s:%s"}`, user, pass)
+	}))
+	defer srv.Close()
+
+	for _, cfg := range []*Config{
+		{InfluxServer: srv.URL, InfluxUsername: "wx", InfluxPassword: "pw-s3cret"},
+		{InfluxServer: srv.URL, InfluxToken: "tok-s3cret"},
+		{InfluxServer: strings.Replace(srv.URL, "://", "://wx:url-s3cret@", 1)},
+	} {
+		if err := cfg.splitServerCredentials(); err != nil {
+			t.Fatal(err)
+		}
+		RegisterSecret(cfg.InfluxPassword)
+		RegisterSecret(cfg.InfluxToken)
+		secret := cfg.InfluxPassword + cfg.InfluxToken
+
+		username, password := cfg.influxCredentials()
+		client, err := NewInfluxClient(InfluxClientOptions{
+			Addr:     cfg.InfluxServer,
+			Username: username,
+			Password: password,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = influxHealthcheck(client)
+		_ = client.Close()
+		if err == nil {
+			t.Fatal("expected the ping to fail")
+		}
+		if !strings.Contains(err.Error(), secret) {
+			t.Fatalf("test server didn't echo the secret; error: %s", err)
+		}
+
+		var buf bytes.Buffer
+		logger := log.New(RedactingWriter{W: &buf}, "", 0)
+		logger.Printf("InfluxDB ping failed: %s", err)
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("logged error contains the secret: %s", buf.String())
+		}
+		if !strings.Contains(buf.String(), redactedPlaceholder) {
+			t.Errorf("logged error isn't redacted: %s", buf.String())
+		}
+	}
+}
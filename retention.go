@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// retentionPolicy describes one of a database's retention policies.
+type retentionPolicy struct {
+	Name     string
+	Duration time.Duration // 0 means data is kept forever
+	Default  bool
+}
+
+// defaultRetentionPolicy returns the default retention policy of the source
+// database, or nil if it has none.
+func defaultRetentionPolicy(client influxdb.Client, cfg *Config) (*retentionPolicy, error) {
+	q := fmt.Sprintf(`SHOW RETENTION POLICIES ON "%s"`, cfg.SourceDB())
+	log.Printf("[DEBUG] query: %s", q)
+	r, err := client.Query(influxdb.Query{Command: q, Database: cfg.SourceDB()})
+	if err == nil && r.Error() != nil {
+		err = r.Error()
+	}
+	if err != nil {
+		return nil, &aggregate.QueryError{Query: q, Err: err}
+	}
+	if len(r.Results) == 0 || len(r.Results[0].Series) == 0 {
+		return nil, nil
+	}
+	series := r.Results[0].Series[0]
+	nameIdx := slices.Index(series.Columns, "name")
+	durationIdx := slices.Index(series.Columns, "duration")
+	defaultIdx := slices.Index(series.Columns, "default")
+	if nameIdx < 0 || durationIdx < 0 || defaultIdx < 0 {
+		return nil, &aggregate.SchemaError{Msg: fmt.Sprintf("expected name, duration, and default columns, got columns %v", series.Columns)}
+	}
+	for _, row := range series.Values {
+		if isDefault, _ := row[defaultIdx].(bool); !isDefault {
+			continue
+		}
+		name, _ := row[nameIdx].(string)
+		durationStr, _ := row[durationIdx].(string)
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return nil, &aggregate.ParseError{What: "retention policy duration", Err: err}
+		}
+		return &retentionPolicy{Name: name, Duration: duration, Default: true}, nil
+	}
+	return nil, nil
+}
+
+// resolveRetentionPolicy looks up the database's default retention policy when
+// INFLUX_RP is unset, warning if it keeps data for less time than the widest window
+// an enabled aggregator reads, since that aggregate would silently cover less than
+// its interval. With -detect-rp, the default policy is then used explicitly.
+func resolveRetentionPolicy(client influxdb.Client, cfg *Config) error {
+	if cfg.InfluxRP != "" {
+		return nil
+	}
+	rp, err := defaultRetentionPolicy(client, cfg)
+	if err == nil && rp == nil {
+		err = fmt.Errorf("database '%s' has no default retention policy", cfg.SourceDB())
+	}
+	if err != nil {
+		if cfg.DetectRP {
+			return fmt.Errorf("failed to detect the default retention policy: %w", err)
+		}
+		log.Printf("WARNING: could not determine the default retention policy to check its duration: %s", err)
+		return nil
+	}
+
+	if widest := widestEnabledWindow(cfg); rp.Duration != 0 && rp.Duration < widest {
+		log.Printf("WARNING: the default retention policy '%s' keeps data for only %s, but aggregates cover up to %s; set INFLUX_RP_ARCHIVE or a longer INFLUX_RP",
+			rp.Name, rp.Duration, widest)
+	}
+	if cfg.DetectRP {
+		log.Printf("using the default retention policy '%s'", rp.Name)
+		cfg.InfluxRP = rp.Name
+	}
+	return nil
+}
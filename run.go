@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// runner holds everything a run needs which is set up once at startup, so that
+// daemon mode can run repeatedly with the same client and settings.
+type runner struct {
+	cfg          *Config
+	client       influxdb.Client
+	store        aggregate.Store
+	qTags        map[string]string
+	wTags        map[string]string
+	sampleFilter *aggregate.SampleFilter
+	now          func() time.Time
+	diag         *diagRecorder // records each run's queries for -diag-dir; nil if unset
+	runs         int           // runs completed so far, in daemon mode
+}
+
+// runContext returns the context for a single run, which is done once the run
+// exceeds -run-timeout, if set.
+func runContext(cfg *Config) (context.Context, context.CancelFunc) {
+	if cfg.RunTimeout > 0 {
+		return context.WithTimeout(context.Background(), cfg.RunTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// queryClient returns the client a run queries InfluxDB through, which retries
+// transient query failures, records query and write times in summary, and records
+// queries for -diag-dir if set.
+func (r *runner) queryClient(summary *aggregate.RunSummary) influxdb.Client {
+	if r.diag != nil {
+		return timedClient{retryingClient{r.diag}, summary}
+	}
+	return timedClient{retryingClient{r.client}, summary}
+}
+
+const (
+	MaxPointsActionError = "error"
+	MaxPointsActionWarn  = "warn"
+)
+
+// TooManyPointsError describes a run which computed more than -max-points points.
+type TooManyPointsError struct {
+	Points int
+	Max    int
+}
+
+func (e *TooManyPointsError) Error() string {
+	return fmt.Sprintf("computed %d points, more than -max-points %d; none were written", e.Points, e.Max)
+}
+
+// computeAll runs computeGroups, then checks the number of points computed against
+// -max-points, if set. With -source http, the feed sample has been written already,
+// since aggregations must read it, and isn't counted (except with -dry-run, which
+// returns it with the rest).
+func (r *runner) computeAll(ctx context.Context, summary *aggregate.RunSummary) ([]*influxdb.Point, error) {
+	points, err := r.computeGroups(ctx, summary)
+	if err != nil {
+		return nil, err
+	}
+	if limit := r.cfg.MaxPoints; limit > 0 && len(points) > limit {
+		if r.cfg.MaxPointsAction != MaxPointsActionWarn {
+			return nil, &TooManyPointsError{Points: len(points), Max: limit}
+		}
+		log.Printf("WARNING: computed %d points, more than -max-points %d", len(points), limit)
+	}
+	return points, nil
+}
+
+// computeGroups runs compute once, or with -group-by, once for each source series
+// group, with that group's tag values added to the tags queried and written.
+func (r *runner) computeGroups(ctx context.Context, summary *aggregate.RunSummary) ([]*influxdb.Point, error) {
+	if r.diag != nil {
+		r.diag.reset()
+	}
+	if len(r.cfg.GroupBy) == 0 {
+		return r.compute(ctx, summary)
+	}
+
+	groups, err := seriesGroups(ctx, r.queryClient(summary), r.cfg, r.qTags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find source series to group by: %w", err)
+	}
+	if len(groups) == 0 {
+		if r.failOnEmpty() {
+			return nil, &aggregate.EmptyResultError{Msg: "no source series to aggregate"}
+		}
+		log.Printf("no source series to aggregate")
+		return nil, nil
+	}
+	var points []*influxdb.Point
+	for _, group := range groups {
+		gr := *r
+		gr.qTags = withGroup(r.qTags, group)
+		gr.wTags = withGroup(r.wTags, group)
+		p, err := gr.compute(ctx, summary)
+		if err != nil {
+			return nil, fmt.Errorf("series %s: %w", groupString(group), err)
+		}
+		points = append(points, p...)
+	}
+	return points, nil
+}
+
+// compute polls the station feed (if configured) and runs every enabled aggregator,
+// returning the points to write. In dry-run mode the feed sample is returned with
+// them rather than written.
+func (r *runner) compute(ctx context.Context, summary *aggregate.RunSummary) ([]*influxdb.Point, error) {
+	cfg := r.cfg
+	client := r.queryClient(summary)
+	store := r.store
+	store.Influx = client
+
+	// compute time is whatever this takes beyond waiting on InfluxDB:
+	start := time.Now()
+	ioBefore := summary.QueryTime + summary.WriteTime
+	defer func() {
+		summary.AddComputeTime(time.Since(start) - (summary.QueryTime + summary.WriteTime - ioBefore))
+	}()
+
+	var points []*influxdb.Point
+
+	if cfg.Source == SourceHTTP && !cfg.Explain {
+		feedPoint, err := FeedPoint(FeedArgs{
+			URL:           cfg.FeedURL,
+			Fields:        cfg.FeedFields,
+			MeasurementTo: cfg.SourceMeasurement,
+			WriteTags:     r.qTags,
+			Timeout:       influxReadTimeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to poll station feed: %w", err)
+		}
+		if cfg.DryRun {
+			points = append(points, feedPoint)
+		} else if err := writePointsTo(client, cfg, cfg.SourceDB(), []*influxdb.Point{feedPoint}); err != nil {
+			// the sample must be written before aggregating so that it is included:
+			return nil, fmt.Errorf("failed to write station feed sample: %w", err)
+		}
+	}
+
+	periods, _ := aggregate.ParsePeriods(cfg.Periods) // validated by Config.Validate
+	now := r.now
+	if cfg.WindowType == aggregate.WindowTumbling || len(periods) > 0 {
+		// every aggregator must agree on which fixed windows are the latest complete ones:
+		runTime := r.now()
+		now = func() time.Time { return runTime }
+	}
+
+	var aggPoints []*influxdb.Point
+	for _, agg := range EnabledAggregators(cfg) {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("run abandoned before aggregation '%s': %w", agg.Name(), err)
+		}
+		if !r.due(agg.Name()) {
+			log.Printf("[DEBUG] aggregation '%s' not due this run", agg.Name())
+			continue
+		}
+		if dep, ok := agg.(dependentAggregator); ok {
+			agg = dep.withInputs(aggPoints)
+		}
+		common := aggregate.CommonArgs{
+			MeasurementFrom:   cfg.SourceMeasurement,
+			MeasurementTo:     cfg.Measurement + "_agg",
+			QueryTags:         r.qTags,
+			WriteTags:         r.wTags,
+			FieldSuffix:       cfg.FieldSuffix,
+			MinSamples:        cfg.MinSamples,
+			TimestampStrategy: cfg.TimestampStrategy,
+			Layout:            cfg.Layout,
+			WindowType:        cfg.WindowType,
+			WindowStart:       cfg.WindowStart,
+			WindowTolerance:   cfg.WindowTolerance,
+			Periods:           periods,
+			FailOnEmpty:       r.failOnEmpty(),
+			NonFinite:         cfg.NonFinite,
+			NonFiniteValue:    cfg.NonFiniteSentinel,
+			SourceFilter:      cfg.Filters[agg.Name()],
+			SourceFields:      cfg.SourceFields,
+			Filter:            r.sampleFilter,
+			Summary:           summary,
+			Now:               now,
+		}
+		p, err := agg.Run(ctx, store, common)
+		if err != nil {
+			if field, ok := r.skippableMissingField(err); ok {
+				log.Printf("WARNING: skipping aggregation '%s': source field '%s' is missing", agg.Name(), field)
+				continue
+			}
+			return nil, fmt.Errorf("aggregation '%s' failed: %w", agg.Name(), err)
+		}
+		aggPoints = append(aggPoints, p...)
+	}
+
+	var units aggregate.FieldUnits
+	if cfg.DualUnits {
+		units = enabledFieldUnits(cfg)
+	}
+	if cfg.EmitCurrent {
+		currentFields := cfg.CurrentFields()
+		if len(currentFields) > 0 {
+			currentPoint, err := aggregate.CurrentConditions(ctx, aggregate.CurrentArgs{
+				CommonArgs: aggregate.CommonArgs{
+					MeasurementFrom: cfg.SourceMeasurement,
+					MeasurementTo:   cfg.Measurement + "_current",
+					QueryTags:       r.qTags,
+					WriteTags:       r.wTags,
+					FieldSuffix:     cfg.FieldSuffix,
+					SourceFields:    cfg.SourceFields,
+					Filter:          r.sampleFilter,
+					NonFinite:       cfg.NonFinite,
+					NonFiniteValue:  cfg.NonFiniteSentinel,
+					FailOnEmpty:     r.failOnEmpty(),
+				},
+				Store:  store,
+				Fields: currentFields,
+			}, aggPoints)
+			if field, ok := r.skippableMissingField(err); ok {
+				log.Printf("WARNING: skipping current conditions: source field '%s' is missing", field)
+				err, currentPoint = nil, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("current conditions failed: %w", err)
+			}
+			if currentPoint != nil {
+				current, err := aggregate.DualUnitPoints([]*influxdb.Point{currentPoint}, units, cfg.FieldSuffix)
+				if err != nil {
+					return nil, err
+				}
+				if current, err = aggregate.RoundPoints(current, aggregate.Rounding(cfg.Round)); err != nil {
+					return nil, err
+				}
+				points = append(points, current...)
+			}
+		}
+	}
+
+	// unwritten intervals are dropped only now, since current conditions may use them:
+	var err error
+	if len(cfg.WriteIntervals) > 0 {
+		writeIntervals, _ := cfg.WriteIntervalDurations() // validated by Config.Validate
+		if aggPoints, err = aggregate.KeepIntervals(aggPoints, writeIntervals, cfg.FieldSuffix); err != nil {
+			return nil, err
+		}
+	}
+	// converted values are rounded too, so units are converted first:
+	if aggPoints, err = aggregate.DualUnitPoints(aggPoints, units, cfg.FieldSuffix); err != nil {
+		return nil, err
+	}
+	if aggPoints, err = aggregate.RoundPoints(aggPoints, aggregate.Rounding(cfg.Round)); err != nil {
+		return nil, err
+	}
+
+	// rounding precedes this comparison, so that values which differ only beyond the
+	// written precision are considered unchanged:
+	if cfg.SkipUnchanged && !cfg.Explain && len(aggPoints) > 0 {
+		aggPoints, err = aggregate.DropUnchangedPoints(ctx, store, r.qTags, aggPoints, cfg.UnchangedTolerance)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare with stored aggregates: %w", err)
+		}
+	}
+	// window bounds change every run, so they're added only once unchanged points are dropped:
+	if cfg.WindowBounds {
+		aggPoints, err = aggregate.WindowBoundsPoints(aggPoints, aggregate.CommonArgs{
+			FieldSuffix:       cfg.FieldSuffix,
+			TimestampStrategy: cfg.TimestampStrategy,
+			Layout:            cfg.Layout,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(points, aggPoints...), nil
+}
+
+// failOnEmpty reports whether a source query returning no data fails the run. It
+// never does with -explain, whose queries all return no data.
+func (r *runner) failOnEmpty() bool {
+	return r.cfg.FailOnEmpty && !r.cfg.Explain
+}
+
+// skippableMissingField returns the missing source field which caused err, if
+// -skip-missing-fields is set and that's the cause.
+func (r *runner) skippableMissingField(err error) (string, bool) {
+	var se *aggregate.SchemaError
+	if !r.cfg.SkipMissingFields || !errors.As(err, &se) || se.Field == "" {
+		return "", false
+	}
+	return se.Field, true
+}
+
+// logRunTimings logs how long the given run spent on queries, computation, and
+// writes. The same timings are fields of the -emit-run-metadata point.
+func logRunTimings(summary *aggregate.RunSummary) {
+	log.Printf("run timings: %s", summary.Timings())
+}
+
+// emitRunMetadata writes a point recording the given run's statistics, if
+// -emit-run-metadata is set. Failures are logged but don't fail the run.
+func (r *runner) emitRunMetadata(summary *aggregate.RunSummary) {
+	if !r.cfg.EmitRunMetadata || r.cfg.DryRun || r.cfg.Explain {
+		return
+	}
+	p, err := summary.MetadataPoint(r.cfg.Measurement+"_agg_runs", r.wTags)
+	if err == nil {
+		err = writePoints(r.client, r.cfg, []*influxdb.Point{p})
+	}
+	if err != nil {
+		errLog.Printf("failed to write run metadata: %s", err)
+	}
+}
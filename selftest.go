@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// selfTestFields are written by -selftest and must read back unchanged. They cover
+// every field type this program writes, including a float which needs full
+// precision and a string which needs escaping in line protocol.
+var selfTestFields = map[string]any{
+	"float":   12.345678901234567,
+	"integer": int64(-42),
+	"string":  `NNW "VAR", \ test`,
+	"boolean": true,
+}
+
+// runSelfTest writes a point with known values to the <measurement>_selftest
+// measurement, reads it back, verifies every field and tag, and deletes it. It returns an
+// error describing the first failure or discrepancy.
+func runSelfTest(client influxdb.Client, cfg *Config, wTags map[string]string) (retErr error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return fmt.Errorf("failed to generate self-test ID: %w", err)
+	}
+	id := hex.EncodeToString(idBytes)
+	measurement := cfg.Measurement + "_selftest"
+
+	tags := maps.Clone(wTags)
+	tags["selftest_id"] = id
+	ts := time.Now().Truncate(time.Microsecond)
+	p, err := influxdb.NewPoint(measurement, tags, selfTestFields, ts)
+	if err != nil {
+		return fmt.Errorf("failed to create self-test point: %w", err)
+	}
+
+	log.Printf("self-test: writing %s", p.String())
+	if err := writePoints(client, cfg, []*influxdb.Point{p}); err != nil {
+		return err
+	}
+	defer func() {
+		q := fmt.Sprintf(`DROP SERIES FROM "%s" WHERE selftest_id='%s'`, measurement, id)
+		if err := selfTestQuery(client, cfg, q, nil); err != nil {
+			retErr = errors.Join(retErr, fmt.Errorf("failed to delete self-test point: %w", err))
+		}
+	}()
+
+	q := fmt.Sprintf(`SELECT * FROM "%s" WHERE selftest_id='%s'`, measurement, id)
+	var r *influxdb.Response
+	if err := selfTestQuery(client, cfg, q, &r); err != nil {
+		return err
+	}
+	if len(r.Results) == 0 || len(r.Results[0].Series) == 0 || len(r.Results[0].Series[0].Values) == 0 {
+		return errors.New("self-test point was written but could not be read back")
+	}
+	series := r.Results[0].Series[0]
+	if len(series.Values) != 1 {
+		return fmt.Errorf("expected 1 self-test point, read back %d", len(series.Values))
+	}
+	row := series.Values[0]
+	value := func(col string) (any, error) {
+		idx := slices.Index(series.Columns, col)
+		if idx < 0 {
+			return nil, fmt.Errorf("self-test point read back without column '%s'", col)
+		}
+		return row[idx], nil
+	}
+
+	v, err := value("time")
+	if err != nil {
+		return err
+	}
+	if s, ok := v.(string); !ok {
+		return fmt.Errorf("self-test time: read back %v (%T)", v, v)
+	} else if t, err := time.Parse(time.RFC3339Nano, s); err != nil || !t.Equal(ts) {
+		return fmt.Errorf("self-test time: wrote %s, read back %s", ts.Format(time.RFC3339Nano), s)
+	}
+	for name, want := range selfTestFields {
+		got, err := value(name)
+		if err != nil {
+			return err
+		}
+		if !selfTestValueEqual(want, got) {
+			return fmt.Errorf("self-test field '%s': wrote %v (%T), read back %v (%T)", name, want, want, got, got)
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		got, err := value(k)
+		if err != nil {
+			return err
+		}
+		if got != tags[k] {
+			return fmt.Errorf("self-test tag '%s': wrote %s, read back %v", k, tags[k], got)
+		}
+	}
+	return nil
+}
+
+// selfTestValueEqual reports whether a value read back from InfluxDB, which decodes
+// numbers as json.Number, exactly equals the value written.
+func selfTestValueEqual(want, got any) bool {
+	n, isNumber := got.(json.Number)
+	switch want := want.(type) {
+	case float64:
+		f, err := n.Float64()
+		return isNumber && err == nil && f == want
+	case int64:
+		i, err := n.Int64()
+		return isNumber && err == nil && i == want
+	default:
+		return got == want
+	}
+}
+
+func selfTestQuery(client influxdb.Client, cfg *Config, q string, r **influxdb.Response) error {
+	log.Printf("self-test: %s", q)
+	resp, err := client.Query(influxdb.Query{Command: q, Database: cfg.DestDB(), RetentionPolicy: cfg.InfluxRP})
+	if err == nil && resp.Error() != nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return &aggregate.QueryError{Query: q, Err: err}
+	}
+	if r != nil {
+		*r = resp
+	}
+	return nil
+}
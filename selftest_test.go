@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// echoClient reads back the point written to it, with mangle applied to its row.
+type echoClient struct {
+	influxdb.Client
+	written *influxdb.Point
+	mangle  func(row map[string]any)
+}
+
+func (c *echoClient) Write(bp influxdb.BatchPoints) error {
+	c.written = bp.Points()[0]
+	return nil
+}
+
+func (c *echoClient) Query(q influxdb.Query) (*influxdb.Response, error) {
+	if !strings.HasPrefix(q.Command, "SELECT") {
+		return &influxdb.Response{}, nil
+	}
+	fields, _ := c.written.Fields()
+	row := map[string]any{"time": c.written.Time().UTC().Format(time.RFC3339Nano)}
+	for k, v := range fields {
+		switch v.(type) {
+		case float64, int64:
+			row[k] = json.Number(fmt.Sprint(v))
+		default:
+			row[k] = v
+		}
+	}
+	for k, v := range c.written.Tags() {
+		row[k] = v
+	}
+	c.mangle(row)
+	series := models.Row{Name: c.written.Name()}
+	var values []any
+	for k, v := range row {
+		series.Columns = append(series.Columns, k)
+		values = append(values, v)
+	}
+	series.Values = [][]any{values}
+	return &influxdb.Response{Results: []influxdb.Result{{Series: []models.Row{series}}}}, nil
+}
+
+func TestSelfTestVerifiesTags(t *testing.T) {
+	cfg := &Config{Measurement: "wx", InfluxDB: "wx"}
+	wTags := map[string]string{"aggregator": "test", "station": "backyard"}
+	tests := []struct {
+		name    string
+		mangle  func(row map[string]any)
+		wantErr string
+	}{
+		{"unchanged", func(map[string]any) {}, ""},
+		{"output tag changed", func(row map[string]any) { row["station"] = "frontyard" }, "self-test tag 'station'"},
+		{"output tag missing", func(row map[string]any) { delete(row, "aggregator") }, "without column 'aggregator'"},
+		{"field changed", func(row map[string]any) { row["integer"] = json.Number("42") }, "self-test field 'integer'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runSelfTest(&echoClient{mangle: tt.mangle}, cfg, wTags)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
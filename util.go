@@ -2,11 +2,20 @@ package main
 
 import (
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/cdzombak/wx-sta-agg-influx/aggregate"
 )
 
 func ParseTags(tags string) (map[string]string, error) {
 	retv := make(map[string]string)
+	if tags == "" {
+		return retv, nil
+	}
 	for _, tag := range strings.Split(tags, ",") {
 		parts := strings.Split(tag, "=")
 		if len(parts) != 2 {
@@ -17,13 +26,202 @@ func ParseTags(tags string) (map[string]string, error) {
 	return retv, nil
 }
 
-func PartialWhereClauseForTags(tags map[string]string) string {
-	if len(tags) == 0 {
-		return ""
+// ParseFieldList parses a comma-separated list of field names, ignoring empty entries.
+func ParseFieldList(fields string) []string {
+	var retv []string
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			retv = append(retv, f)
+		}
+	}
+	return retv
+}
+
+// SourceFieldsFlag is a repeatable flag of the form <field>=<source-field>, mapping
+// the field name used in flags and outputs to the column actually read from the
+// source measurement.
+type SourceFieldsFlag map[string]string
+
+func (m SourceFieldsFlag) String() string {
+	parts := make([]string, 0, len(m))
+	for k, v := range m {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (m SourceFieldsFlag) Set(value string) error {
+	name, src, ok := strings.Cut(value, "=")
+	name, src = strings.TrimSpace(name), strings.TrimSpace(src)
+	if !ok || name == "" || src == "" {
+		return fmt.Errorf("expected <field>=<source-field>, got '%s'", value)
+	}
+	if strings.ContainsAny(src, `"\`) {
+		return fmt.Errorf("source field name must not contain quotes or backslashes: '%s'", src)
+	}
+	m[name] = src
+	return nil
+}
+
+// ParseSentinels parses a comma-separated list of sentinel values.
+func ParseSentinels(sentinels string) ([]float64, error) {
+	var retv []float64
+	for _, part := range ParseFieldList(sentinels) {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sentinel value '%s': %w", part, err)
+		}
+		retv = append(retv, v)
+	}
+	return retv, nil
+}
+
+// ClampRangesFlag is a repeatable flag of the form <field>:<min>:<max>.
+type ClampRangesFlag map[string]aggregate.ValueRange
+
+func (c ClampRangesFlag) String() string {
+	parts := make([]string, 0, len(c))
+	for k, v := range c {
+		parts = append(parts, fmt.Sprintf("%s:%g:%g", k, v.Min, v.Max))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func (c ClampRangesFlag) Set(value string) error {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return fmt.Errorf("expected <field>:<min>:<max>, got '%s'", value)
+	}
+	minV, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid min in '%s': %w", value, err)
+	}
+	maxV, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return fmt.Errorf("invalid max in '%s': %w", value, err)
+	}
+	if minV > maxV {
+		return fmt.Errorf("min must be <= max in '%s'", value)
+	}
+	c[parts[0]] = aggregate.ValueRange{Min: minV, Max: maxV}
+	return nil
+}
+
+// NumericFieldsFlag is a repeatable flag of the form <field>:<stat>[,<stat>...],
+// naming a field for the generic numeric aggregator and the statistics to compute.
+type NumericFieldsFlag []aggregate.NumericField
+
+func (n *NumericFieldsFlag) String() string {
+	parts := make([]string, 0, len(*n))
+	for _, f := range *n {
+		parts = append(parts, f.Name+":"+strings.Join(f.Stats, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (n *NumericFieldsFlag) Set(value string) error {
+	name, statsIn, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("expected <field>:<stat>[,<stat>...], got '%s'", value)
+	}
+	for _, f := range *n {
+		if f.Name == name {
+			return fmt.Errorf("field '%s' given more than once", name)
+		}
+	}
+	stats, err := aggregate.ParseNumericStats(statsIn)
+	if err != nil {
+		return fmt.Errorf("invalid -numeric '%s': %w", value, err)
+	}
+	*n = append(*n, aggregate.NumericField{Name: name, Stats: stats})
+	return nil
+}
+
+// RollupsFlag is a repeatable flag of the form <field>_<interval>:<stat>:<interval>,
+// rolling up an aggregate field's stored values over a longer interval.
+type RollupsFlag []aggregate.Rollup
+
+func (r *RollupsFlag) String() string {
+	parts := make([]string, 0, len(*r))
+	for _, rollup := range *r {
+		parts = append(parts, rollup.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r *RollupsFlag) Set(value string) error {
+	rollup, err := aggregate.ParseRollup(value)
+	if err != nil {
+		return fmt.Errorf("invalid -rollup '%s': %w", value, err)
+	}
+	if slices.Contains(*r, rollup) {
+		return fmt.Errorf("rollup '%s' given more than once", value)
+	}
+	*r = append(*r, rollup)
+	return nil
+}
+
+// SchedulesFlag is a repeatable flag of the form <aggregation>:<interval>, running
+// an aggregation in daemon mode only every <interval>.
+type SchedulesFlag map[string]time.Duration
+
+func (s SchedulesFlag) String() string {
+	parts := make([]string, 0, len(s))
+	for k, v := range s {
+		parts = append(parts, k+":"+v.String())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func (s SchedulesFlag) Set(value string) error {
+	aggName, intervalIn, ok := strings.Cut(value, ":")
+	aggName = strings.TrimSpace(aggName)
+	if !ok || aggName == "" {
+		return fmt.Errorf("expected <aggregation>:<interval>, got '%s'", value)
+	}
+	interval, err := time.ParseDuration(strings.TrimSpace(intervalIn))
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid interval '%s' in -schedule; expected a positive duration, e.g. 5m", intervalIn)
+	}
+	s[aggName] = interval
+	return nil
+}
+
+// RoundFlag is a repeatable flag of the form <places>, rounding every float output
+// field, or <field>=<places>, rounding output fields named <field> or <field>_...
+type RoundFlag aggregate.Rounding
+
+func (r RoundFlag) String() string {
+	parts := make([]string, 0, len(r))
+	for k, v := range r {
+		if k == "" {
+			parts = append(parts, strconv.Itoa(v))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s=%d", k, v))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (r RoundFlag) Set(value string) error {
+	field, placesIn, ok := strings.Cut(value, "=")
+	if !ok {
+		field, placesIn = "", value
+	}
+	field = strings.TrimSpace(field)
+	if ok && field == "" {
+		return fmt.Errorf("expected <places> or <field>=<places>, got '%s'", value)
 	}
-	var parts []string
-	for k, v := range tags {
-		parts = append(parts, fmt.Sprintf(`%s='%s'`, k, v))
+	places, err := strconv.Atoi(strings.TrimSpace(placesIn))
+	if err != nil || places < 0 || places > 15 {
+		return fmt.Errorf("decimal places must be an integer from 0 to 15, got '%s'", placesIn)
 	}
-	return " AND " + strings.Join(parts, " AND ")
+	r[field] = places
+	return nil
 }
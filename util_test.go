@@ -0,0 +1,30 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseSentinels(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []float64
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"-9999", []float64{-9999}, false},
+		{"-9999,6553.5", []float64{-9999, 6553.5}, false},
+		{" -9999 , 6553.5 ", []float64{-9999, 6553.5}, false},
+		{"-9999,x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSentinels(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSentinels(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseSentinels(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}